@@ -0,0 +1,756 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// Largest request body the api will read
+const apiMaxBodySize = 1 << 20
+
+/* - Note as it is sent by the api - */
+type apiNote struct {
+	Id             int32             `json:"id"`
+	Owner          int32             `json:"owner"`
+	Share          []int32           `json:"share"`
+	Visibility     string            `json:"visibility"`  // private, shared or everyone
+	ShareRoles     map[int32]string  `json:"share_roles"` // role of each user in share
+	Groups         map[int32]string  `json:"groups"`      // role of each group the note is shared with
+	Name           string            `json:"name"`
+	Date           time.Time         `json:"date"`
+	CompletionDate time.Time         `json:"completion_date"`
+	Flag           int               `json:"flag"`
+	Content        string            `json:"content"`
+	ContentHtml    string            `json:"content_html"`
+	Checklist      ChecklistProgress `json:"checklist"`
+	Tags           []string          `json:"tags"`
+	Notebook       int32             `json:"notebook"`
+	DueDate        *string           `json:"due_date"` // YYYY-MM-DD or null
+	Priority       int               `json:"priority"`
+	Overdue        bool              `json:"overdue"`
+	Assignees      []int32           `json:"assignees"`
+}
+
+/* - Body of a create or update note request, missing fields are left unchanged on update - */
+type apiNoteRequest struct {
+	Name    *string  `json:"name"`
+	Content *string  `json:"content"`
+	Flag    *int     `json:"flag"`
+	Share   *[]int32 `json:"share"`
+	// Who can see the note ("private", "shared" or "everyone"), new notes are shared (or private if shared with nobody)
+	Visibility *string `json:"visibility"`
+	// Role of shared users ("viewer", "commenter", "editor" or "co-owner"), anyone left out is a viewer
+	ShareRoles *map[int32]string `json:"share_roles"`
+	// Groups to share with and their roles, only groups you are in can be added and groups you aren't in are kept
+	Groups   *map[int32]string `json:"groups"`
+	Tags     *[]string         `json:"tags"`
+	Notebook *int32            `json:"notebook"`
+	DueDate  *string           `json:"due_date"` // YYYY-MM-DD, "" removes the due date
+	Priority *int              `json:"priority"`
+}
+
+/* - User as it is sent by the api (never includes the password hash) - */
+type apiUser struct {
+	Id       int32  `json:"id"`
+	Username string `json:"username"`
+}
+
+/* - Settings as they are sent by the api - */
+type apiSettings struct {
+	Colleagues     []int32 `json:"colleagues"`
+	ColleaguesOnly bool    `json:"colleagues_only"` // only colleagues can share notes with the user
+}
+
+/* - Body of a settings update, colleagues are only changed with invitations so the field is ignored - */
+type apiSettingsRequest struct {
+	Colleagues     *[]int32 `json:"colleagues"`
+	ColleaguesOnly *bool    `json:"colleagues_only"`
+}
+
+/* - Body of every error response - */
+type apiError struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+/*
+- Adds the api routes to a subrouter (mounted on /api/v1)
+Args:
+
+	r: subrouter for the api
+*/
+func (a *App) initApiRouter(r *mux.Router) {
+	r.HandleFunc("/notes", a.apiListNotesHandler).Methods("GET")
+	r.HandleFunc("/notes", a.apiCreateNoteHandler).Methods("POST")
+	r.HandleFunc("/notes/{id:[0-9]+}", a.apiGetNoteHandler).Methods("GET")
+	r.HandleFunc("/notes/{id:[0-9]+}", a.apiUpdateNoteHandler).Methods("PUT")
+	r.HandleFunc("/notes/{id:[0-9]+}", a.apiDeleteNoteHandler).Methods("DELETE")
+	r.HandleFunc("/notes/{id:[0-9]+}/assignees", a.apiAssignNoteHandler).Methods("PUT")
+	r.HandleFunc("/notes/{id:[0-9]+}/assignments", a.apiListAssignmentsHandler).Methods("GET")
+	r.HandleFunc("/notes/{id:[0-9]+}/comments", a.apiListCommentsHandler).Methods("GET")
+	r.HandleFunc("/notes/{id:[0-9]+}/comments", a.apiCreateCommentHandler).Methods("POST")
+	r.HandleFunc("/notes/{id:[0-9]+}/links", a.apiListNoteLinksHandler).Methods("GET")
+	r.HandleFunc("/notes/{id:[0-9]+}/links", a.apiCreateNoteLinkHandler).Methods("POST")
+	r.HandleFunc("/notes/{id:[0-9]+}/links/{link:[0-9]+}", a.apiRevokeNoteLinkHandler).Methods("DELETE")
+	r.HandleFunc("/notes/{id:[0-9]+}/checklist", a.apiGetChecklistHandler).Methods("GET")
+	r.HandleFunc("/notes/{id:[0-9]+}/checklist/{item:[0-9]+}", a.apiSetChecklistItemHandler).Methods("PUT")
+	r.HandleFunc("/search", a.apiListNotesHandler).Methods("GET")
+	r.HandleFunc("/searches", a.apiListSavedSearchesHandler).Methods("GET")
+	r.HandleFunc("/searches", a.apiCreateSavedSearchHandler).Methods("POST")
+	r.HandleFunc("/searches/{id:[0-9]+}", a.apiDeleteSavedSearchHandler).Methods("DELETE")
+	r.HandleFunc("/notebooks", a.apiListNotebooksHandler).Methods("GET")
+	r.HandleFunc("/groups", a.apiListGroupsHandler).Methods("GET")
+	r.HandleFunc("/groups", a.apiCreateGroupHandler).Methods("POST")
+	r.HandleFunc("/groups/{id:[0-9]+}", a.apiUpdateGroupHandler).Methods("PUT")
+	r.HandleFunc("/groups/{id:[0-9]+}", a.apiDeleteGroupHandler).Methods("DELETE")
+	r.HandleFunc("/workflow", a.apiGetWorkflowHandler).Methods("GET")
+	r.HandleFunc("/tags", a.apiListTagsHandler).Methods("GET")
+	r.HandleFunc("/users", a.apiListUsersHandler).Methods("GET")
+	r.HandleFunc("/settings", a.apiGetSettingsHandler).Methods("GET")
+	r.HandleFunc("/settings", a.apiUpdateSettingsHandler).Methods("PUT")
+	r.HandleFunc("/colleagues/requests", a.apiListColleagueRequestsHandler).Methods("GET")
+	r.HandleFunc("/colleagues/requests", a.apiInviteColleagueHandler).Methods("POST")
+	r.HandleFunc("/colleagues/requests/{id:[0-9]+}/accept", a.apiAcceptColleagueHandler).Methods("POST")
+	r.HandleFunc("/colleagues/requests/{id:[0-9]+}", a.apiDeleteColleagueRequestHandler).Methods("DELETE")
+	r.HandleFunc("/colleagues/{id:[0-9]+}", a.apiRemoveColleagueHandler).Methods("DELETE")
+	r.HandleFunc("/tokens", a.apiListTokensHandler).Methods("GET")
+	r.HandleFunc("/tokens", a.apiCreateTokenHandler).Methods("POST")
+	r.HandleFunc("/tokens/{id:[0-9]+}", a.apiRevokeTokenHandler).Methods("DELETE")
+
+	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSONError(w, http.StatusNotFound, "no such endpoint")
+	})
+	r.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+	})
+}
+
+/*
+- Sends a value to the client as json
+Args:
+
+	w: http response writer
+	status: http status code
+	v: value to encode
+*/
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Print(err)
+	}
+}
+
+/*
+- Sends an error to the client as json
+*/
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, apiError{Status: status, Message: msg})
+}
+
+/*
+- Logs an internal error and sends a generic 500 to the client
+*/
+func writeJSONInternalError(w http.ResponseWriter, err error) {
+	log.Print(err)
+	writeJSONError(w, http.StatusInternalServerError, "internal server error")
+}
+
+/*
+- Reads a json request body
+Args:
+
+	w: http response writer
+	r: http request
+	v: value to decode into
+
+return: true if the body was decoded, otherwise an error has already been sent
+*/
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, apiMaxBodySize))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(v); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+/*
+- Gets the current user for an api request
+Args:
+
+	w: http response writer
+	r: http request
+
+return: the user and true, or false if an error has already been sent
+*/
+func (a *App) apiCurrentUser(w http.ResponseWriter, r *http.Request) (User, bool) {
+	if !hasValidCredentials(r) {
+		writeJSONError(w, http.StatusUnauthorized, "not logged in")
+		return User{}, false
+	}
+
+	user, err := a.fetchCurrentUser(r)
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return User{}, false
+	}
+
+	return user, true
+}
+
+/*
+- Gets the note in the request path if the user can view it
+Args:
+
+	w: http response writer
+	r: http request
+	user: current user
+
+return: the note and true, or false if an error has already been sent
+*/
+func (a *App) apiNoteFromPath(w http.ResponseWriter, r *http.Request, user User) (Note, bool) {
+	id, err := getIdFromPath(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid note id")
+		return Note{}, false
+	}
+
+	note, err := a.fetchNote(id)
+	switch {
+	case err == sql.ErrNoRows:
+		writeJSONError(w, http.StatusNotFound, "note not found")
+		return Note{}, false
+	case err != nil:
+		writeJSONInternalError(w, err)
+		return Note{}, false
+	case !canViewNote(user, note):
+		// Don't reveal that the note exists
+		writeJSONError(w, http.StatusNotFound, "note not found")
+		return Note{}, false
+	}
+
+	return note, true
+}
+
+func toApiNote(note Note) apiNote {
+	share := []int32(note.Share)
+	if share == nil {
+		share = []int32{}
+	}
+
+	tags := []string(note.Tags)
+	if tags == nil {
+		tags = []string{}
+	}
+
+	assignees := []int32(note.Assignees)
+	if assignees == nil {
+		assignees = []int32{}
+	}
+
+	roles := map[int32]string{}
+	for _, id := range note.Share {
+		if id > 0 {
+			roles[id] = shareRoleToString(note.ShareRoles.role(id))
+		}
+	}
+
+	groups := map[int32]string{}
+	for id := range note.GroupShares {
+		groups[id] = shareRoleToString(note.GroupShares.role(id))
+	}
+
+	var dueDate *string
+	if note.DueDate.Valid {
+		due := note.DueDate.Time.Format("2006-01-02")
+		dueDate = &due
+	}
+
+	return apiNote{
+		Id:             note.Id,
+		Owner:          note.Owner,
+		Share:          share,
+		Visibility:     noteVisibilityToString(note.Visibility),
+		ShareRoles:     roles,
+		Groups:         groups,
+		Name:           note.Name,
+		Date:           note.Date,
+		CompletionDate: note.CompletionDate,
+		Flag:           note.Flag,
+		Content:        note.Content,
+		ContentHtml:    string(renderMarkdown(note.Content)),
+		Checklist:      checklistProgress(note.Content),
+		Tags:           tags,
+		Notebook:       note.Notebook,
+		DueDate:        dueDate,
+		Priority:       note.Priority,
+		Overdue:        isOverdue(note, time.Now()),
+		Assignees:      assignees,
+	}
+}
+
+func toApiUsers(users []User) []apiUser {
+	apiUsers := make([]apiUser, 0, len(users))
+	for _, u := range users {
+		apiUsers = append(apiUsers, apiUser{Id: u.Id, Username: u.Username})
+	}
+	return apiUsers
+}
+
+/*
+- Keeps only the ids of other existing users, same as the share fieldset would
+Args:
+
+	ids: user ids sent by the client
+	otherUsers: list of users excluding the current one
+
+return: list of user ids
+*/
+func filterShareIds(ids []int32, otherUsers []User) pq.Int32Array {
+	share := pq.Int32Array{}
+
+	for _, id := range ids {
+		for _, u := range otherUsers {
+			if u.Id == id {
+				share = append(share, id)
+				break
+			}
+		}
+	}
+
+	return share
+}
+
+/*
+- Applies the fields of a note request to a note
+Args:
+
+	req: decoded request body
+	note: note to change
+	user: current user
+	otherUsers: list of users excluding the current one
+	notebooks: every notebook
+	wf: workflow, the flag can only be changed along its transitions
+	groups: every group
+
+return: an error message for the client or an empty string
+*/
+func applyNoteRequest(req apiNoteRequest, note *Note, user User, otherUsers []User, notebooks []Notebook, wf Workflow, groups []Group) string {
+	if req.Name != nil {
+		if *req.Name == "" {
+			return "name can't be empty"
+		}
+		name := *req.Name
+		note.Name = truncateRunes(name, NoteNameMaxLength)
+	}
+
+	if req.Content != nil {
+		note.Content = *req.Content
+	}
+
+	if req.Flag != nil {
+		// Notes that haven't been saved yet have no id and can start in any state
+		move := wf.moveNote
+		if note.Id == 0 {
+			move = wf.startNote
+		}
+		if msg := move(note, *req.Flag); msg != "" {
+			return msg
+		}
+	}
+
+	if req.Tags != nil {
+		note.Tags = parseTags(strings.Join(*req.Tags, ","))
+	}
+
+	if req.DueDate != nil {
+		due, ok := parseDueDate(*req.DueDate)
+		if !ok {
+			return "due_date must be written as YYYY-MM-DD"
+		}
+		note.DueDate = due
+	}
+
+	if req.Priority != nil {
+		if !isValidNotePriority(*req.Priority) {
+			return "invalid note priority"
+		}
+		note.Priority = *req.Priority
+	}
+
+	if (req.Share != nil || req.Visibility != nil || req.ShareRoles != nil || req.Groups != nil) && canShareNote(user, *note) {
+		if req.Share != nil {
+			note.Share = filterShareIds(*req.Share, otherUsers)
+		}
+
+		if req.Visibility != nil {
+			visibility, valid := parseNoteVisibility(*req.Visibility)
+			if !valid {
+				return "visibility is private, shared or everyone"
+			}
+			note.Visibility = visibility
+		}
+
+		roles := ShareRoles{}
+		for _, id := range note.Share {
+			roles[id] = note.ShareRoles.role(id)
+			if req.ShareRoles == nil {
+				continue
+			}
+
+			roles[id] = NoteAccessViewer
+			if name, ok := (*req.ShareRoles)[id]; ok {
+				role, valid := parseShareRole(name)
+				if !valid {
+					return "share roles are viewer, commenter, editor or co-owner"
+				}
+				roles[id] = role
+			}
+		}
+
+		note.ShareRoles = roles
+
+		if req.Groups != nil {
+			myGroups := userGroups(user, groups)
+			edited := ShareRoles{}
+			for id, name := range *req.Groups {
+				if !slices.ContainsFunc(myGroups, func(g Group) bool { return g.Id == id }) {
+					return "notes can only be shared with groups you are in"
+				}
+				role, valid := parseShareRole(name)
+				if !valid {
+					return "share roles are viewer, commenter, editor or co-owner"
+				}
+				edited[id] = role
+			}
+			note.GroupShares = mergeGroupShares(note.GroupShares, edited, myGroups)
+			note.GroupRoles = groupRoles(note.GroupShares, groups)
+		}
+
+		// Don't quietly drop the users and groups that were asked for
+		if msg := checkVisibility(*note, user, (req.Share != nil && len(note.Share) > 0) || (req.Groups != nil && len(note.GroupShares) > 0)); msg != "" {
+			return msg
+		}
+
+		*note = keepCoOwner(normaliseVisibility(*note), user)
+	}
+
+	if req.Notebook != nil && canMoveNote(user, *note) {
+		if !isValidNotebookParent(user, *req.Notebook, 0, notebooks) {
+			return "notes can only be put in notebooks you own"
+		}
+		note.Notebook = *req.Notebook
+	}
+
+	return ""
+}
+
+// GET /api/v1/notes?q=&user=&flag=&date=&tag=&notebook=&sort=&order=&limit=&after=&before=
+// The next and previous pages are sent in a Link header
+func (a *App) apiListNotesHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.apiCurrentUser(w, r)
+	if !ok {
+		return
+	}
+
+	wf, err := a.fetchWorkflow()
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	search := parseSearchQuery(r.URL.Query())
+	page := parsePageQuery(r.URL.Query())
+	result, err := a.searchNotes(user, search, page, wf)
+	var searchErr *SearchError
+	if errors.As(err, &searchErr) {
+		writeJSONError(w, http.StatusBadRequest, searchErr.Error())
+		return
+	}
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	links := []string{}
+	if result.Next != "" {
+		next := PageQuery{Sort: page.Sort, Order: page.Order, Limit: page.Limit, After: result.Next}
+		links = append(links, "<"+r.URL.Path+next.Encode(search)+">; rel=\"next\"")
+	}
+	if result.Prev != "" {
+		prev := PageQuery{Sort: page.Sort, Order: page.Order, Limit: page.Limit, Before: result.Prev}
+		links = append(links, "<"+r.URL.Path+prev.Encode(search)+">; rel=\"prev\"")
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+
+	apiNotes := make([]apiNote, 0, len(result.Notes))
+	for _, note := range result.Notes {
+		apiNotes = append(apiNotes, toApiNote(note))
+	}
+
+	writeJSON(w, http.StatusOK, apiNotes)
+}
+
+// GET /api/v1/notes/{id}
+func (a *App) apiGetNoteHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.apiCurrentUser(w, r)
+	if !ok {
+		return
+	}
+
+	note, ok := a.apiNoteFromPath(w, r, user)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, toApiNote(note))
+}
+
+// POST /api/v1/notes
+func (a *App) apiCreateNoteHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.apiCurrentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req apiNoteRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	if req.Name == nil || req.Content == nil {
+		writeJSONError(w, http.StatusBadRequest, "name and content are required")
+		return
+	}
+
+	otherUsers, err := a.fetchUsersExclude(user)
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	note := Note{
+		Owner:          user.Id,
+		Share:          pq.Int32Array{},
+		Visibility:     NoteVisibilityShared,
+		Date:           time.Now(),
+		CompletionDate: time.Now(),
+		Flag:           NoteFlagNote,
+	}
+	notebooks, err := a.fetchNotebooks()
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	wf, err := a.fetchWorkflow()
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	groups, err := a.fetchGroups()
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	if req.Share != nil && !a.apiCheckShareTargets(w, user, filterShareIds(*req.Share, otherUsers), note.Share) {
+		return
+	}
+
+	if msg := applyNoteRequest(req, &note, user, otherUsers, notebooks, wf, groups); msg != "" {
+		writeJSONError(w, http.StatusBadRequest, msg)
+		return
+	}
+	note = normaliseVisibility(note)
+
+	note.Id, err = a.insertNote(note)
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, toApiNote(note))
+}
+
+// PUT /api/v1/notes/{id}
+func (a *App) apiUpdateNoteHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.apiCurrentUser(w, r)
+	if !ok {
+		return
+	}
+
+	note, ok := a.apiNoteFromPath(w, r, user)
+	if !ok {
+		return
+	}
+
+	if !canEditNote(user, note) {
+		writeJSONError(w, http.StatusForbidden, "you do not have permission to edit this note")
+		return
+	}
+
+	var req apiNoteRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	if (req.Share != nil || req.Visibility != nil || req.ShareRoles != nil || req.Groups != nil) && !canShareNote(user, note) {
+		writeJSONError(w, http.StatusForbidden, "only the owner and co-owners can change who a note is shared with")
+		return
+	}
+
+	if req.Notebook != nil && !canMoveNote(user, note) {
+		writeJSONError(w, http.StatusForbidden, "only the owner can change a note's notebook")
+		return
+	}
+
+	otherUsers, err := a.fetchUsersExclude(user)
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	notebooks, err := a.fetchNotebooks()
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	wf, err := a.fetchWorkflow()
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	groups, err := a.fetchGroups()
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	if req.Share != nil && !a.apiCheckShareTargets(w, user, filterShareIds(*req.Share, otherUsers), note.Share) {
+		return
+	}
+
+	if msg := applyNoteRequest(req, &note, user, otherUsers, notebooks, wf, groups); msg != "" {
+		writeJSONError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	if err := a.updateNote(note, user); err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, toApiNote(note))
+}
+
+// DELETE /api/v1/notes/{id}
+func (a *App) apiDeleteNoteHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.apiCurrentUser(w, r)
+	if !ok {
+		return
+	}
+
+	note, ok := a.apiNoteFromPath(w, r, user)
+	if !ok {
+		return
+	}
+
+	if !canDeleteNote(user, note) {
+		writeJSONError(w, http.StatusForbidden, "you do not have permission to delete this note")
+		return
+	}
+
+	if err := a.deleteNote(note); err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET /api/v1/users
+func (a *App) apiListUsersHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.apiCurrentUser(w, r)
+	if !ok {
+		return
+	}
+
+	otherUsers, err := a.fetchUsersExclude(user)
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, toApiUsers(append([]User{user}, otherUsers...)))
+}
+
+func toApiSettings(settings UserSettings) apiSettings {
+	colleagues := []int32(settings.Colleagues)
+	if colleagues == nil {
+		colleagues = []int32{}
+	}
+	return apiSettings{Colleagues: colleagues, ColleaguesOnly: settings.ColleaguesOnly}
+}
+
+// GET /api/v1/settings
+func (a *App) apiGetSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.apiCurrentUser(w, r)
+	if !ok {
+		return
+	}
+
+	settings, err := a.fetchUserSettings(user)
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, toApiSettings(settings))
+}
+
+// PUT /api/v1/settings, body {"colleagues_only": true}
+func (a *App) apiUpdateSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.apiCurrentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req apiSettingsRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	if req.ColleaguesOnly != nil {
+		_, err := a.db.Exec("UPDATE user_settings SET share_colleagues_only=$1 WHERE user_id=$2", *req.ColleaguesOnly, user.Id)
+		if err != nil {
+			writeJSONInternalError(w, err)
+			return
+		}
+	}
+
+	settings, err := a.fetchUserSettings(user)
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, toApiSettings(settings))
+}
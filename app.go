@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/icza/session"
 	_ "github.com/jackc/pgx/v5/stdlib"
 )
 
@@ -23,6 +24,12 @@ type App struct {
 	Router   *mux.Router
 	db       *sql.DB
 	bindport string
+
+	sessionStore   *pgStore
+	trashRetention time.Duration
+	admins         []string // usernames allowed to change the workflow
+	publicUrl      string   // address public links are given out on, without a trailing '/'
+	linkAttempts   *linkAttempts
 	//username string
 	//role     string
 }
@@ -91,14 +98,57 @@ func initRouter(a *App) *mux.Router {
 	r.HandleFunc("/login", a.loginHandler).Methods("POST", "GET")
 	r.HandleFunc("/register", a.registerHandler).Methods("POST", "GET")
 	r.HandleFunc("/logout", a.logoutHandler).Methods("GET")
+	r.HandleFunc("/logout/others", a.logoutOthersHandler).Methods("POST")
 	r.HandleFunc("/dashboard", a.dashboardHandler).Methods("GET")
 
+	// Public links, these need no account
+	r.HandleFunc("/s/{token:[0-9a-f]{64}}", a.publicNoteHandler).Methods("GET", "POST")
+
 	// Note handle
 	r.HandleFunc("/search", a.searchHandler).Methods("POST")
-	r.HandleFunc("/create", a.createNoteHandler).Methods("POST")
-	r.HandleFunc("/edit", a.editNoteHandler).Methods("POST")
-	r.HandleFunc("/delete", a.deleteNoteHandler).Methods("POST")
+	r.HandleFunc("/notes", a.createNoteHandler).Methods("POST")
+	r.HandleFunc("/notes/preview", a.previewNoteHandler).Methods("POST")
+	r.HandleFunc("/notes/{id:[0-9]+}/edit", a.editNoteHandler).Methods("POST")
+	r.HandleFunc("/notes/{id:[0-9]+}/delete", a.deleteNoteHandler).Methods("POST")
+	r.HandleFunc("/notes/{id:[0-9]+}/restore", a.restoreNoteHandler).Methods("POST")
+	r.HandleFunc("/notes/{id:[0-9]+}/purge", a.purgeNoteHandler).Methods("POST")
+	r.HandleFunc("/notes/{id:[0-9]+}/assign", a.assignNoteHandler).Methods("POST")
+	r.HandleFunc("/notes/{id:[0-9]+}/comments", a.commentNoteHandler).Methods("POST")
+	r.HandleFunc("/notes/{id:[0-9]+}/links", a.createNoteLinkHandler).Methods("POST")
+	r.HandleFunc("/notes/{id:[0-9]+}/links/{link:[0-9]+}/revoke", a.revokeNoteLinkHandler).Methods("POST")
+	r.HandleFunc("/notes/{id:[0-9]+}/history", a.noteHistoryHandler).Methods("GET")
+	r.HandleFunc("/notes/{id:[0-9]+}/history/{rev:[0-9]+}/restore", a.restoreRevisionHandler).Methods("POST")
 	r.HandleFunc("/editsettings", a.editSettingsHandler).Methods("POST")
+	r.HandleFunc("/colleagues/requests", a.inviteColleagueHandler).Methods("POST")
+	r.HandleFunc("/colleagues/requests/{id:[0-9]+}/accept", a.acceptColleagueHandler).Methods("POST")
+	r.HandleFunc("/colleagues/requests/{id:[0-9]+}/delete", a.deleteColleagueRequestHandler).Methods("POST")
+	r.HandleFunc("/colleagues/{id:[0-9]+}/remove", a.removeColleagueHandler).Methods("POST")
+	r.HandleFunc("/notebooks", a.createNotebookHandler).Methods("POST")
+	r.HandleFunc("/notebooks/{id:[0-9]+}/edit", a.editNotebookHandler).Methods("POST")
+	r.HandleFunc("/notebooks/{id:[0-9]+}/delete", a.deleteNotebookHandler).Methods("POST")
+	r.HandleFunc("/searches", a.saveSearchHandler).Methods("POST")
+	r.HandleFunc("/searches/{id:[0-9]+}/pin", a.pinSearchHandler).Methods("POST")
+	r.HandleFunc("/searches/{id:[0-9]+}/delete", a.deleteSearchHandler).Methods("POST")
+	r.HandleFunc("/groups", a.groupsHandler).Methods("GET")
+	r.HandleFunc("/groups", a.createGroupHandler).Methods("POST")
+	r.HandleFunc("/groups/{id:[0-9]+}/edit", a.editGroupHandler).Methods("POST")
+	r.HandleFunc("/groups/{id:[0-9]+}/delete", a.deleteGroupHandler).Methods("POST")
+	r.HandleFunc("/groups/{id:[0-9]+}/members", a.addGroupMemberHandler).Methods("POST")
+	r.HandleFunc("/groups/{id:[0-9]+}/members/{user:[0-9]+}/edit", a.editGroupMemberHandler).Methods("POST")
+	r.HandleFunc("/groups/{id:[0-9]+}/members/{user:[0-9]+}/delete", a.removeGroupMemberHandler).Methods("POST")
+	r.HandleFunc("/workflow", a.workflowHandler).Methods("GET")
+	r.HandleFunc("/workflow/states", a.createStateHandler).Methods("POST")
+	r.HandleFunc("/workflow/states/{id:[0-9]+}/edit", a.editStateHandler).Methods("POST")
+	r.HandleFunc("/workflow/states/{id:[0-9]+}/delete", a.deleteStateHandler).Methods("POST")
+	r.HandleFunc("/workflow/transitions", a.setTransitionsHandler).Methods("POST")
+	r.HandleFunc("/tokens", a.createTokenHandler).Methods("POST")
+	r.HandleFunc("/tokens/{id:[0-9]+}/revoke", a.revokeTokenHandler).Methods("POST")
+
+	// JSON api
+	a.initApiRouter(r.PathPrefix("/api/v1").Subrouter())
+
+	// Api tokens are accepted on every route
+	r.Use(a.tokenAuthMiddleware)
 
 	return r
 }
@@ -128,9 +178,26 @@ func InitApp() (App, error) {
 		a.importData()
 	}
 
+	log.Println("Running database migrations")
+	if err = a.runMigrations(); err != nil {
+		return App{}, err
+	}
+
 	log.Println("Successfully connected to PostgreSQL server")
 
-	setupAuth()
+	a.setupAuth()
+
+	a.trashRetention = findTrashRetention()
+	log.Printf("Notes are purged from the trash after %s\n", a.trashRetention)
+	go a.trashPurger(TrashPurgeInterval)
+
+	a.admins = findAdmins()
+	log.Printf("Admins: %v\n", a.admins)
+
+	a.publicUrl = findPublicUrl(a.bindport)
+	log.Printf("Public links are given out on %s\n", a.publicUrl)
+	a.linkAttempts = newLinkAttempts()
+
 	a.Router = initRouter(&a)
 
 	return a, nil
@@ -172,6 +239,7 @@ func (a *App) Run() {
 	defer cancel()
 	log.Println("shutting HTTP service down")
 	srv.Shutdown(ctx)
+	session.Global.Close()
 	log.Println("closing database connections")
 	a.db.Close()
 	log.Println("shutting down")
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+/* - Assignment history entry as it is sent by the api - */
+type apiNoteAssignment struct {
+	Id         int32     `json:"id"`
+	AssignedBy int32     `json:"assigned_by"`
+	AssignedTo []int32   `json:"assigned_to"`
+	Date       time.Time `json:"date"`
+}
+
+/* - Body of an assign request, an empty list unassigns the note - */
+type apiAssignRequest struct {
+	Assignees *[]int32 `json:"assignees"`
+}
+
+/*
+- Checks that every assignee exists and can see the note, a note can't be assigned to someone it isn't shared with
+Args:
+
+	note: note being assigned
+	ids: user ids to assign the note to
+	users: every user
+
+return: the assignees without duplicates, or an error message naming the first user that can't be assigned
+*/
+func checkAssignees(note Note, ids []int32, users []User) ([]int32, string) {
+	assignees := []int32{}
+	for _, id := range ids {
+		i := slices.IndexFunc(users, func(u User) bool { return u.Id == id })
+		if i == -1 {
+			return nil, "user " + strconv.Itoa(int(id)) + " doesn't exist"
+		}
+		if !canViewNote(users[i], note) {
+			return nil, users[i].Username + " can't see this note, share it with them first"
+		}
+		if !slices.Contains(assignees, id) {
+			assignees = append(assignees, id)
+		}
+	}
+	return assignees, ""
+}
+
+/*
+- Assigns a note and records who assigned it, assigning a note that is still open marks it as delegated
+Args:
+
+	note: note to assign
+	assignees: users to assign it to (already checked with checkAssignees), empty to unassign
+	by: user assigning the note
+
+return: the note as it was saved or an error
+*/
+func (a *App) assignNote(note Note, assignees []int32, by User) (Note, error) {
+	wf, err := a.fetchWorkflow()
+	if err != nil {
+		return note, err
+	}
+
+	tx, err := a.db.Begin()
+	if err != nil {
+		return note, err
+	}
+	defer tx.Rollback()
+
+	// Closed notes, and notes the workflow doesn't allow to become delegated, keep their state
+	flagChanged := false
+	if len(assignees) > 0 && !note.Terminal && note.Flag != NoteFlagDelegated && wf.canMove(note.Flag, NoteFlagDelegated) {
+		flagChanged = wf.moveNote(&note, NoteFlagDelegated) == ""
+	}
+	note.Assignees = assignees
+
+	_, err = tx.Exec("UPDATE notes SET note_assignees=$1, note_flag=$2, note_completion_date=$3 WHERE note_id=$4",
+		note.Assignees, note.Flag, note.CompletionDate, note.Id)
+	if err != nil {
+		return note, err
+	}
+
+	_, err = tx.Exec("INSERT INTO note_assignments(note_id, assigned_by, assigned_to, assignment_date) VALUES($1, $2, $3, $4)",
+		note.Id, by.Id, note.Assignees, time.Now())
+	if err != nil {
+		return note, err
+	}
+
+	// The status is part of the note's history, who it is assigned to is kept in note_assignments
+	if flagChanged {
+		if err = insertNoteRevision(tx, note, by.Id); err != nil {
+			return note, err
+		}
+	}
+
+	return note, tx.Commit()
+}
+
+/*
+- Fetches every time a note was assigned, newest first
+Args:
+
+	noteId: note_id of the note
+
+return: list of assignments or an error
+*/
+func (a *App) fetchNoteAssignments(noteId int32) ([]NoteAssignment, error) {
+	rows, err := a.db.Query("SELECT assignment_id, note_id, assigned_by, assigned_to, assignment_date FROM note_assignments WHERE note_id=$1 ORDER BY assignment_id DESC",
+		noteId)
+	if err != nil {
+		return make([]NoteAssignment, 0), err
+	}
+	defer rows.Close()
+
+	assignments := []NoteAssignment{}
+	for rows.Next() {
+		var as NoteAssignment
+		if e := rows.Scan(&as.Id, &as.NoteId, &as.AssignedBy, &as.AssignedTo, &as.Date); e != nil {
+			return make([]NoteAssignment, 0), e
+		}
+		assignments = append(assignments, as)
+	}
+
+	return assignments, nil
+}
+
+func (a *App) assignNoteHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAuthenticated(w, r) {
+		return
+	}
+
+	user, err := a.fetchCurrentUser(r)
+	checkInternalServerError(err, w)
+
+	noteId, err := getIdFromPath(r)
+	if err != nil {
+		http.Error(w, "invalid note id", http.StatusBadRequest)
+		return
+	}
+
+	note, err := a.fetchNote(noteId)
+	switch {
+	case err == sql.ErrNoRows:
+		setFlash(w, FlashDashboard, "That note no longer exists")
+		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		return
+	case err != nil:
+		checkInternalServerError(err, w)
+		return
+	case !canEditNote(user, note):
+		forbidden(w)
+		return
+	}
+
+	r.ParseForm()
+	var ids []int32
+	for _, v := range r.Form["assignee"] {
+		if id, err := strconv.Atoi(v); err == nil {
+			ids = append(ids, int32(id))
+		}
+	}
+
+	users, err := a.fetchUsersExclude(User{})
+	checkInternalServerError(err, w)
+
+	assignees, msg := checkAssignees(note, ids, users)
+	if msg != "" {
+		setFlash(w, FlashDashboard, "Couldn't assign '"+note.Name+"': "+msg)
+		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		return
+	}
+
+	_, err = a.assignNote(note, assignees, user)
+	checkInternalServerError(err, w)
+
+	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+}
+
+// PUT /api/v1/notes/{id}/assignees, body {"assignees": [ids]}
+func (a *App) apiAssignNoteHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.apiCurrentUser(w, r)
+	if !ok {
+		return
+	}
+
+	note, ok := a.apiNoteFromPath(w, r, user)
+	if !ok {
+		return
+	}
+
+	if !canEditNote(user, note) {
+		writeJSONError(w, http.StatusForbidden, "you do not have permission to assign this note")
+		return
+	}
+
+	var req apiAssignRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.Assignees == nil {
+		writeJSONError(w, http.StatusBadRequest, "assignees is required")
+		return
+	}
+
+	users, err := a.fetchUsersExclude(User{})
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	assignees, msg := checkAssignees(note, *req.Assignees, users)
+	if msg != "" {
+		writeJSONError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	note, err = a.assignNote(note, assignees, user)
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, toApiNote(note))
+}
+
+// GET /api/v1/notes/{id}/assignments
+func (a *App) apiListAssignmentsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.apiCurrentUser(w, r)
+	if !ok {
+		return
+	}
+
+	note, ok := a.apiNoteFromPath(w, r, user)
+	if !ok {
+		return
+	}
+
+	assignments, err := a.fetchNoteAssignments(note.Id)
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	apiAssignments := make([]apiNoteAssignment, 0, len(assignments))
+	for _, as := range assignments {
+		to := []int32(as.AssignedTo)
+		if to == nil {
+			to = []int32{}
+		}
+		apiAssignments = append(apiAssignments, apiNoteAssignment{
+			Id:         as.Id,
+			AssignedBy: as.AssignedBy,
+			AssignedTo: to,
+			Date:       as.Date,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, apiAssignments)
+}
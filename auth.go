@@ -2,6 +2,7 @@ package main
 
 import (
 	"database/sql"
+	"fmt"
 	"html/template"
 	"log"
 	"net/http"
@@ -11,31 +12,53 @@ import (
 )
 
 /*
-- Checks if the current session is valid
+- Checks if the current session is valid without responding to the client
 Args:
 
-	w: http response writer
 	r: http request
 
 return: true if current session is valid
 */
-func isAuthenticated(w http.ResponseWriter, r *http.Request) bool {
-	authenticated := false
-
-	//m := map[string]interface{}{}
-
+func hasValidSession(r *http.Request) bool {
 	// get the current session variables
 	sess := session.Get(r)
+	if sess == nil {
+		return false
+	}
 
-	if sess != nil {
-		u := sess.CAttr("username").(string)
-		c := sess.Attr("count").(int)
+	u, _ := sess.CAttr("username").(string)
+	c, _ := sess.Attr("count").(int)
 
-		//just a simple authentication check for the current user
-		if c > 0 && len(u) > 0 {
-			authenticated = true
-		}
+	//just a simple authentication check for the current user
+	return c > 0 && len(u) > 0
+}
+
+/*
+- Checks if the request has a valid session or was sent with a valid api token
+Args:
+
+	r: http request
+
+return: true if the request is authenticated
+*/
+func hasValidCredentials(r *http.Request) bool {
+	if _, ok := tokenUserFromRequest(r); ok {
+		return true
 	}
+	return hasValidSession(r)
+}
+
+/*
+- Checks if the current session (or api token) is valid, redirects to the login page if it isn't
+Args:
+
+	w: http response writer
+	r: http request
+
+return: true if current session is valid
+*/
+func isAuthenticated(w http.ResponseWriter, r *http.Request) bool {
+	authenticated := hasValidCredentials(r)
 
 	if !authenticated {
 		http.Redirect(w, r, "/login", http.StatusMovedPermanently)
@@ -45,30 +68,34 @@ func isAuthenticated(w http.ResponseWriter, r *http.Request) bool {
 }
 
 /*
-- Setup a cookie manager for sessions
+- Setup a cookie manager for sessions, sessions are stored in the database
 */
-func setupAuth() {
+func (a *App) setupAuth() {
 	// Initialize the session manager - this is a global
 	// For testing purposes, we want cookies to be sent over HTTP too (not just HTTPS)
 	// refer to the auth.go for the authentication handlers using the sessions
+	a.sessionStore = newPgStore(a.db)
 	session.Global.Close()
-	session.Global = session.NewCookieManagerOptions(session.NewInMemStore(), &session.CookieMngrOptions{AllowHTTP: true})
+	session.Global = session.NewCookieManagerOptions(a.sessionStore, &session.CookieMngrOptions{
+		AllowHTTP:    true,
+		CookieMaxAge: SessionMaxLifetime,
+	})
+
+	setupFlash()
 }
 
+/* - Template data for the login and register pages, messages come from flash cookies - */
 type AuthData struct {
 	LogErrMsg string
 	RegErrMsg string
 }
 
-var authData AuthData = AuthData{LogErrMsg: "", RegErrMsg: ""}
-
 func (a *App) loginHandler(w http.ResponseWriter, r *http.Request) {
 	method := r.Method
 
 	if method != "POST" {
 		executeTemplate(w, "login.html", "web/login.html",
-			template.FuncMap{}, authData)
-		authData.LogErrMsg = ""
+			template.FuncMap{}, AuthData{LogErrMsg: popFlash(w, r, FlashLogin)})
 		return
 	}
 
@@ -80,7 +107,7 @@ func (a *App) loginHandler(w http.ResponseWriter, r *http.Request) {
 		username).Scan(&user.Id, &user.Username, &user.Password)
 
 	if err == sql.ErrNoRows {
-		authData.LogErrMsg = "Incorrect Username"
+		setFlash(w, FlashLogin, "Incorrect Username")
 		http.Redirect(w, r, "/login", http.StatusMovedPermanently)
 		return
 	}
@@ -89,13 +116,11 @@ func (a *App) loginHandler(w http.ResponseWriter, r *http.Request) {
 
 	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
 	if err != nil {
-		authData.LogErrMsg = "Incorrect Password"
+		setFlash(w, FlashLogin, "Incorrect Password")
 		http.Redirect(w, r, "/login", http.StatusMovedPermanently)
 		return
 	}
 
-	authData.LogErrMsg = ""
-
 	// Successful Login
 	createUserSession(w, user)
 	http.Redirect(w, r, "/dashboard", http.StatusMovedPermanently)
@@ -106,8 +131,7 @@ func (a *App) registerHandler(w http.ResponseWriter, r *http.Request) {
 
 	if method != "POST" {
 		executeTemplate(w, "register.html", "web/register.html",
-			template.FuncMap{}, authData)
-		authData.RegErrMsg = ""
+			template.FuncMap{}, AuthData{RegErrMsg: popFlash(w, r, FlashRegister)})
 		return
 	}
 
@@ -120,7 +144,7 @@ func (a *App) registerHandler(w http.ResponseWriter, r *http.Request) {
 
 	// User name can't contain spaces. My reasoning is that sql statements require spaces so sql injection would be impossible
 	if !ValidateString(username, []rune{' '}, []ValidateRequire{}) {
-		authData.RegErrMsg = "Username can't contain spaces"
+		setFlash(w, FlashRegister, "Username can't contain spaces")
 		http.Redirect(w, r, "/register", http.StatusMovedPermanently)
 		return
 	}
@@ -131,7 +155,7 @@ func (a *App) registerHandler(w http.ResponseWriter, r *http.Request) {
 			'(', ')', '-', '_', '+', '=', ':', ';', '"', '\'',
 			',', '<', '.', '>', '?', '/', '{', '}', '[', ']'}},
 	}) {
-		authData.RegErrMsg = "Password must contain no spaces, atleast two numbers, and atleast 1 special character (e.g. '@')"
+		setFlash(w, FlashRegister, "Password must contain no spaces, atleast two numbers, and atleast 1 special character (e.g. '@')")
 		http.Redirect(w, r, "/register", http.StatusMovedPermanently)
 		return
 	}
@@ -141,8 +165,6 @@ func (a *App) registerHandler(w http.ResponseWriter, r *http.Request) {
 
 	switch {
 	case err == sql.ErrNoRows:
-		authData.RegErrMsg = ""
-
 		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 		checkInternalServerError(err, w)
 
@@ -159,7 +181,7 @@ func (a *App) registerHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "loi: "+err.Error(), http.StatusBadRequest)
 		return
 	default:
-		authData.RegErrMsg = "User Already Exists."
+		setFlash(w, FlashRegister, "User Already Exists.")
 		http.Redirect(w, r, "/register", http.StatusMovedPermanently)
 	}
 }
@@ -172,3 +194,27 @@ func (a *App) logoutHandler(w http.ResponseWriter, r *http.Request) {
 
 	http.Redirect(w, r, "/login", http.StatusMovedPermanently)
 }
+
+func (a *App) logoutOthersHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAuthenticated(w, r) {
+		return
+	}
+
+	user, err := a.fetchCurrentUser(r)
+	checkInternalServerError(err, w)
+
+	// Requests made with an api token have no session to keep
+	currentId := ""
+	if s := session.Get(r); s != nil {
+		currentId = s.ID()
+	}
+
+	n, err := a.sessionStore.removeOtherSessions(user.Id, currentId)
+	if err != nil {
+		checkInternalServerError(err, w)
+		return
+	}
+
+	setFlash(w, FlashDashboard, fmt.Sprintf("Logged out of %d other sessions", n))
+	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/yuin/goldmark/ast"
+	extast "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+/* - A checklist item found in note content - */
+type ChecklistItem struct {
+	Index   int    `json:"index"`
+	Line    int    `json:"line"`
+	Checked bool   `json:"checked"`
+	Text    string `json:"text"`
+	mark    int    // byte offset of the ' ' or 'x' between the brackets
+}
+
+/* - How many checklist items of a note are done - */
+type ChecklistProgress struct {
+	Done  int `json:"done"`
+	Total int `json:"total"`
+}
+
+/* - Body of a checklist item update - */
+type apiChecklistRequest struct {
+	Checked *bool `json:"checked"`
+}
+
+/*
+- Finds the checklist items in note content, in the order they appear. The items are read from the same
+markdown tree renderMarkdown renders, so they are numbered like the checkboxes on the page (items in code
+blocks aren't items, items in block quotes are)
+Args:
+
+	content: note content
+
+return: list of checklist items
+*/
+func parseChecklist(content string) []ChecklistItem {
+	items := []ChecklistItem{}
+	source := []byte(content)
+
+	doc := markdown.Parser().Parse(text.NewReader(source))
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		box, ok := n.(*extast.TaskCheckBox)
+		if !entering || !ok || n.Parent() == nil || n.Parent().Lines().Len() == 0 {
+			return ast.WalkContinue, nil
+		}
+
+		// The checkbox is the start of the first line of the list item's text
+		lineStart := n.Parent().Lines().At(0).Start
+		open := bytes.IndexByte(source[lineStart:], '[')
+		if open == -1 {
+			return ast.WalkContinue, nil
+		}
+		mark := lineStart + open + 1
+
+		lineEnd := bytes.IndexByte(source[mark:], '\n')
+		if lineEnd == -1 {
+			lineEnd = len(source) - mark
+		}
+
+		items = append(items, ChecklistItem{
+			Index:   len(items),
+			Line:    bytes.Count(source[:mark], []byte("\n")),
+			Checked: box.IsChecked,
+			Text:    strings.TrimSpace(string(source[mark+2 : mark+lineEnd])),
+			mark:    mark,
+		})
+		return ast.WalkContinue, nil
+	})
+
+	return items
+}
+
+/*
+- Counts how many checklist items in note content are done
+*/
+func checklistProgress(content string) ChecklistProgress {
+	progress := ChecklistProgress{}
+	for _, item := range parseChecklist(content) {
+		progress.Total++
+		if item.Checked {
+			progress.Done++
+		}
+	}
+	return progress
+}
+
+/*
+- Ticks or unticks a single checklist item, every other line is left exactly as it was
+Args:
+
+	content: note content
+	index: index of the item (see parseChecklist)
+	checked: new state of the item
+
+return: the new content and true, or false if there is no such item
+*/
+func setChecklistItem(content string, index int, checked bool) (string, bool) {
+	items := parseChecklist(content)
+	if index < 0 || index >= len(items) {
+		return content, false
+	}
+
+	mark := " "
+	if checked {
+		mark = "x"
+	}
+
+	at := items[index].mark
+	return content[:at] + mark + content[at+1:], true
+}
+
+// GET /api/v1/notes/{id}/checklist
+func (a *App) apiGetChecklistHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.apiCurrentUser(w, r)
+	if !ok {
+		return
+	}
+
+	note, ok := a.apiNoteFromPath(w, r, user)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, parseChecklist(note.Content))
+}
+
+// PUT /api/v1/notes/{id}/checklist/{item}, body {"checked": true}
+func (a *App) apiSetChecklistItemHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.apiCurrentUser(w, r)
+	if !ok {
+		return
+	}
+
+	note, ok := a.apiNoteFromPath(w, r, user)
+	if !ok {
+		return
+	}
+
+	if !canEditNote(user, note) {
+		writeJSONError(w, http.StatusForbidden, "you can't edit this note")
+		return
+	}
+
+	index, err := strconv.Atoi(mux.Vars(r)["item"])
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid checklist item")
+		return
+	}
+
+	var req apiChecklistRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.Checked == nil {
+		writeJSONError(w, http.StatusBadRequest, "checked is required")
+		return
+	}
+
+	content, found := setChecklistItem(note.Content, index, *req.Checked)
+	if !found {
+		writeJSONError(w, http.StatusNotFound, "checklist item not found")
+		return
+	}
+
+	// Nothing to save if the item is already in that state
+	if content != note.Content {
+		// Only saved if the note hasn't been edited since it was read, otherwise the edit would be lost
+		saved, err := a.updateNoteContent(note, user, content)
+		if err != nil {
+			writeJSONInternalError(w, err)
+			return
+		}
+		if !saved {
+			writeJSONError(w, http.StatusConflict, "the note was changed by someone else, reload it and try again")
+			return
+		}
+
+		if note, err = a.fetchNote(note.Id); err != nil {
+			writeJSONInternalError(w, err)
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, toApiNote(note))
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseChecklist(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []ChecklistItem
+	}{
+		{"none", "just text\n- a list", nil},
+		{"items", "- [ ] milk\n- [x] eggs\n* [X] bread", []ChecklistItem{
+			{Index: 0, Line: 0, Text: "milk"},
+			{Index: 1, Line: 1, Checked: true, Text: "eggs"},
+			{Index: 2, Line: 2, Checked: true, Text: "bread"},
+		}},
+		{"ordered and nested", "1. [ ] one\n   - [x] nested", []ChecklistItem{
+			{Index: 0, Line: 0, Text: "one"},
+			{Index: 1, Line: 1, Checked: true, Text: "nested"},
+		}},
+		{"fenced code", "```\n- [ ] code\n```\n- [ ] real", []ChecklistItem{
+			{Index: 0, Line: 3, Text: "real"},
+		}},
+		{"indented code", "para\n\n    - [ ] code\n\n- [ ] real", []ChecklistItem{
+			{Index: 0, Line: 4, Text: "real"},
+		}},
+		{"block quote", "> - [ ] quoted\n> - [x] done", []ChecklistItem{
+			{Index: 0, Line: 0, Text: "quoted"},
+			{Index: 1, Line: 1, Checked: true, Text: "done"},
+		}},
+		{"windows line endings", "- [ ] a\r\n- [x] b\r\n", []ChecklistItem{
+			{Index: 0, Line: 0, Text: "a"},
+			{Index: 1, Line: 1, Checked: true, Text: "b"},
+		}},
+		{"not at the start of the item", "- buy [ ] milk", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseChecklist(tt.content)
+			for i := range got {
+				got[i].mark = 0
+			}
+			if tt.want == nil {
+				tt.want = []ChecklistItem{}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseChecklist(%q) = %+v, want %+v", tt.content, got, tt.want)
+			}
+		})
+	}
+}
+
+// The dashboard numbers the rendered checkboxes, so both have to find the same items
+func TestChecklistMatchesRenderedCheckboxes(t *testing.T) {
+	contents := []string{
+		"- [ ] a\n- [x] b",
+		"```\n- [ ] code\n```\n- [ ] real",
+		"    - [ ] indented code\n\n- [ ] real",
+		"> - [ ] quoted\n\n- [ ] real",
+		"1. [ ] a\n   > - [x] quoted in a list",
+		"- [ ] a\n\n      - [ ] code in a list",
+		"~~~go\n- [ ] x\n```\n- [ ] still code\n~~~\n- [x] real",
+	}
+
+	for _, content := range contents {
+		rendered := string(renderMarkdown(content))
+		boxes := strings.Count(rendered, `type="checkbox"`)
+		items := parseChecklist(content)
+		if len(items) != boxes {
+			t.Errorf("%q: parseChecklist found %d items but %d checkboxes were rendered", content, len(items), boxes)
+			continue
+		}
+
+		// Ticking every item ticks every rendered checkbox
+		for i := range items {
+			content, _ = setChecklistItem(content, i, true)
+		}
+		if ticked := strings.Count(string(renderMarkdown(content)), "checked"); ticked != boxes {
+			t.Errorf("%q: %d of %d checkboxes ticked", content, ticked, boxes)
+		}
+	}
+}
+
+func TestSetChecklistItem(t *testing.T) {
+	content := "# List\n- [ ] one\n> - [x] two\n\n```\n- [ ] code\n```"
+
+	tests := []struct {
+		index   int
+		checked bool
+		want    string
+		found   bool
+	}{
+		{0, true, "# List\n- [x] one\n> - [x] two\n\n```\n- [ ] code\n```", true},
+		{1, false, "# List\n- [ ] one\n> - [ ] two\n\n```\n- [ ] code\n```", true},
+		{1, true, content, true},
+		{2, true, content, false},
+		{-1, true, content, false},
+	}
+
+	for _, tt := range tests {
+		got, found := setChecklistItem(content, tt.index, tt.checked)
+		if got != tt.want || found != tt.found {
+			t.Errorf("setChecklistItem(%d, %v) = %q, %v, want %q, %v", tt.index, tt.checked, got, found, tt.want, tt.found)
+		}
+	}
+}
+
+func TestChecklistProgress(t *testing.T) {
+	got := checklistProgress("- [x] a\n- [ ] b\n- [X] c")
+	if want := (ChecklistProgress{Done: 2, Total: 3}); got != want {
+		t.Errorf("checklistProgress = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,520 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+/* - Colleague invitation as it is sent by the api - */
+type apiColleagueRequest struct {
+	Id        int32     `json:"id"`
+	Requester int32     `json:"requester"`
+	Recipient int32     `json:"recipient"`
+	Date      time.Time `json:"date"`
+}
+
+/* - Body of a colleague invitation - */
+type apiInviteRequest struct {
+	User *int32 `json:"user"`
+}
+
+/*
+- Fetches the colleague invitations a user has sent or received, oldest first
+Args:
+
+	user: user the invitations are fetched for
+
+return: list of invitations or an error
+*/
+func (a *App) fetchColleagueRequests(user User) ([]ColleagueRequest, error) {
+	rows, err := a.db.Query("SELECT request_id, requester, recipient, request_date FROM colleague_requests WHERE requester=$1 OR recipient=$1 ORDER BY request_id",
+		user.Id)
+	if err != nil {
+		return make([]ColleagueRequest, 0), err
+	}
+	defer rows.Close()
+
+	requests := []ColleagueRequest{}
+	for rows.Next() {
+		var req ColleagueRequest
+		if e := rows.Scan(&req.Id, &req.Requester, &req.Recipient, &req.Date); e != nil {
+			return make([]ColleagueRequest, 0), e
+		}
+		requests = append(requests, req)
+	}
+
+	return requests, nil
+}
+
+/*
+- Sends a colleague invitation
+Args:
+
+	from: user sending the invitation
+	settings: settings of the user sending it
+	to: user_id of the user being invited
+	users: every user
+	requests: invitations the sender has sent or received (see fetchColleagueRequests)
+
+return: the invitation, an error message for the user or an error
+*/
+func (a *App) inviteColleague(from User, settings UserSettings, to int32, users []User, requests []ColleagueRequest) (ColleagueRequest, string, error) {
+	i := slices.IndexFunc(users, func(u User) bool { return u.Id == to })
+	switch {
+	case to == from.Id:
+		return ColleagueRequest{}, "you can't invite yourself", nil
+	case i == -1:
+		return ColleagueRequest{}, "user " + strconv.Itoa(int(to)) + " doesn't exist", nil
+	case slices.Contains(settings.Colleagues, to):
+		return ColleagueRequest{}, "you are already colleagues with " + users[i].Username, nil
+	}
+
+	for _, req := range requests {
+		if req.Requester == from.Id && req.Recipient == to {
+			return ColleagueRequest{}, "you have already invited " + users[i].Username, nil
+		}
+		if req.Requester == to && req.Recipient == from.Id {
+			return ColleagueRequest{}, users[i].Username + " has already invited you, accept their invitation instead", nil
+		}
+	}
+
+	req := ColleagueRequest{Requester: from.Id, Recipient: to, Date: time.Now()}
+	err := a.db.QueryRow("INSERT INTO colleague_requests(requester, recipient, request_date) VALUES($1, $2, $3) RETURNING request_id",
+		req.Requester, req.Recipient, req.Date).Scan(&req.Id)
+
+	return req, "", err
+}
+
+/*
+- Accepts a colleague invitation, both users are added to each other's colleagues
+Args:
+
+	req: invitation being accepted (by its recipient)
+
+return: nil or an error
+*/
+func (a *App) acceptColleagueRequest(req ColleagueRequest) error {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, pair := range [][2]int32{{req.Requester, req.Recipient}, {req.Recipient, req.Requester}} {
+		_, err = tx.Exec("UPDATE user_settings SET colleagues=array_append(COALESCE(colleagues, '{}'), $2) WHERE user_id=$1 AND NOT $2=ANY(COALESCE(colleagues, '{}'))",
+			pair[0], pair[1])
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err = tx.Exec("DELETE FROM colleague_requests WHERE request_id=$1", req.Id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+/*
+- Removes two users from each other's colleagues, either of them can do it
+Args:
+
+	user: user removing the colleague
+	colleagueId: user_id of the colleague
+
+return: nil or an error
+*/
+func (a *App) removeColleague(user User, colleagueId int32) error {
+	_, err := a.db.Exec("UPDATE user_settings SET colleagues=array_remove(colleagues, CASE WHEN user_id=$1 THEN $2 ELSE $1 END) WHERE user_id IN ($1, $2)",
+		user.Id, colleagueId)
+	return err
+}
+
+/*
+- Fetches the users that accept notes shared by a user: everyone apart from users that only accept notes
+from their colleagues and aren't colleagues with them
+Args:
+
+	user: user sharing notes
+
+return: user ids or an error
+*/
+func (a *App) fetchShareTargets(user User) ([]int32, error) {
+	rows, err := a.db.Query("SELECT user_id FROM user_settings WHERE user_id!=$1 AND (NOT share_colleagues_only OR $1=ANY(COALESCE(colleagues, '{}')))",
+		user.Id)
+	if err != nil {
+		return make([]int32, 0), err
+	}
+	defer rows.Close()
+
+	targets := []int32{}
+	for rows.Next() {
+		var id int32
+		if e := rows.Scan(&id); e != nil {
+			return make([]int32, 0), e
+		}
+		targets = append(targets, id)
+	}
+
+	return targets, nil
+}
+
+/*
+- Drops users that don't accept notes from the sharer from a share list, users a note is already shared with stay
+Args:
+
+	share: share list picked by the user (see getShareDetails)
+	current: share list the note has now, nil for a new note
+	targets: users that accept notes from the sharer (see fetchShareTargets)
+
+return: the share list and true if anyone was dropped
+*/
+func keepShareTargets(share, current pq.Int32Array, targets []int32) (pq.Int32Array, bool) {
+	kept := pq.Int32Array{}
+	dropped := false
+	for _, id := range share {
+		if slices.Contains(targets, id) || slices.Contains(current, id) {
+			kept = append(kept, id)
+		} else {
+			dropped = true
+		}
+	}
+
+	return kept, dropped
+}
+
+/*
+- Checks that everyone a note is being shared with over the api accepts notes from the user
+Args:
+
+	w: http response writer
+	user: user sharing the note
+	share: user ids in the request
+	current: share list the note has now, nil for a new note
+
+return: true if the note can be shared, false if an error has already been sent
+*/
+func (a *App) apiCheckShareTargets(w http.ResponseWriter, user User, share []int32, current pq.Int32Array) bool {
+	targets, err := a.fetchShareTargets(user)
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return false
+	}
+
+	if _, dropped := keepShareTargets(share, current, targets); dropped {
+		writeJSONError(w, http.StatusBadRequest, "some of these users only accept notes from their colleagues")
+		return false
+	}
+	return true
+}
+
+/*
+- Gets the colleague invitation in the request path if the current user sent or received it
+Args:
+
+	w: http response writer
+	r: http request
+	requests: invitations the current user has sent or received
+
+return: the invitation and true, or false if a response has already been sent
+*/
+func colleagueRequestFromPath(w http.ResponseWriter, r *http.Request, requests []ColleagueRequest) (ColleagueRequest, bool) {
+	id, err := getIdFromPath(r)
+	if err != nil {
+		http.Error(w, "invalid invitation id", http.StatusBadRequest)
+		return ColleagueRequest{}, false
+	}
+
+	i := slices.IndexFunc(requests, func(req ColleagueRequest) bool { return req.Id == id })
+	if i == -1 {
+		setFlash(w, FlashDashboard, "That invitation no longer exists")
+		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		return ColleagueRequest{}, false
+	}
+
+	return requests[i], true
+}
+
+func (a *App) inviteColleagueHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAuthenticated(w, r) {
+		return
+	}
+
+	user, err := a.fetchCurrentUser(r)
+	checkInternalServerError(err, w)
+
+	to, err := strconv.Atoi(r.FormValue("colleague-invite"))
+	if err != nil {
+		http.Error(w, "invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	settings, err := a.fetchUserSettings(user)
+	checkInternalServerError(err, w)
+
+	users, err := a.fetchUsersExclude(User{})
+	checkInternalServerError(err, w)
+
+	requests, err := a.fetchColleagueRequests(user)
+	checkInternalServerError(err, w)
+
+	_, msg, err := a.inviteColleague(user, settings, int32(to), users, requests)
+	checkInternalServerError(err, w)
+
+	if msg != "" {
+		setFlash(w, FlashDashboard, "Couldn't send the invitation: "+msg)
+	}
+	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+}
+
+func (a *App) acceptColleagueHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAuthenticated(w, r) {
+		return
+	}
+
+	user, err := a.fetchCurrentUser(r)
+	checkInternalServerError(err, w)
+
+	requests, err := a.fetchColleagueRequests(user)
+	checkInternalServerError(err, w)
+
+	req, ok := colleagueRequestFromPath(w, r, requests)
+	if !ok {
+		return
+	}
+
+	// Only the person who was invited can accept
+	if req.Recipient != user.Id {
+		forbidden(w)
+		return
+	}
+
+	err = a.acceptColleagueRequest(req)
+	checkInternalServerError(err, w)
+
+	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+}
+
+/*
+- Declines an invitation that was received or cancels one that was sent
+*/
+func (a *App) deleteColleagueRequestHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAuthenticated(w, r) {
+		return
+	}
+
+	user, err := a.fetchCurrentUser(r)
+	checkInternalServerError(err, w)
+
+	requests, err := a.fetchColleagueRequests(user)
+	checkInternalServerError(err, w)
+
+	req, ok := colleagueRequestFromPath(w, r, requests)
+	if !ok {
+		return
+	}
+
+	_, err = a.db.Exec("DELETE FROM colleague_requests WHERE request_id=$1", req.Id)
+	checkInternalServerError(err, w)
+
+	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+}
+
+func (a *App) removeColleagueHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAuthenticated(w, r) {
+		return
+	}
+
+	user, err := a.fetchCurrentUser(r)
+	checkInternalServerError(err, w)
+
+	colleagueId, err := getIdFromPath(r)
+	if err != nil {
+		http.Error(w, "invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	err = a.removeColleague(user, colleagueId)
+	checkInternalServerError(err, w)
+
+	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+}
+
+func toApiColleagueRequests(requests []ColleagueRequest) []apiColleagueRequest {
+	apiRequests := make([]apiColleagueRequest, 0, len(requests))
+	for _, req := range requests {
+		apiRequests = append(apiRequests, apiColleagueRequest{Id: req.Id, Requester: req.Requester, Recipient: req.Recipient, Date: req.Date})
+	}
+	return apiRequests
+}
+
+/*
+- Gets the colleague invitation in the request path if the current user sent or received it
+return: the invitation and true, or false if an error has already been sent
+*/
+func apiColleagueRequestFromPath(w http.ResponseWriter, r *http.Request, requests []ColleagueRequest) (ColleagueRequest, bool) {
+	id, err := getIdFromPath(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid invitation id")
+		return ColleagueRequest{}, false
+	}
+
+	i := slices.IndexFunc(requests, func(req ColleagueRequest) bool { return req.Id == id })
+	if i == -1 {
+		writeJSONError(w, http.StatusNotFound, "invitation not found")
+		return ColleagueRequest{}, false
+	}
+
+	return requests[i], true
+}
+
+// GET /api/v1/colleagues/requests
+func (a *App) apiListColleagueRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.apiCurrentUser(w, r)
+	if !ok {
+		return
+	}
+
+	requests, err := a.fetchColleagueRequests(user)
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, toApiColleagueRequests(requests))
+}
+
+// POST /api/v1/colleagues/requests, body {"user": id}
+func (a *App) apiInviteColleagueHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.apiCurrentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var body apiInviteRequest
+	if !readJSON(w, r, &body) {
+		return
+	}
+	if body.User == nil {
+		writeJSONError(w, http.StatusBadRequest, "user is required")
+		return
+	}
+
+	settings, err := a.fetchUserSettings(user)
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	users, err := a.fetchUsersExclude(User{})
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	requests, err := a.fetchColleagueRequests(user)
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	req, msg, err := a.inviteColleague(user, settings, *body.User, users, requests)
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+	if msg != "" {
+		writeJSONError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, toApiColleagueRequests([]ColleagueRequest{req})[0])
+}
+
+// POST /api/v1/colleagues/requests/{id}/accept
+func (a *App) apiAcceptColleagueHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.apiCurrentUser(w, r)
+	if !ok {
+		return
+	}
+
+	requests, err := a.fetchColleagueRequests(user)
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	req, ok := apiColleagueRequestFromPath(w, r, requests)
+	if !ok {
+		return
+	}
+
+	if req.Recipient != user.Id {
+		writeJSONError(w, http.StatusForbidden, "only the user that was invited can accept an invitation")
+		return
+	}
+
+	if err = a.acceptColleagueRequest(req); err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	settings, err := a.fetchUserSettings(user)
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, toApiSettings(settings))
+}
+
+// DELETE /api/v1/colleagues/requests/{id}, declines or cancels an invitation
+func (a *App) apiDeleteColleagueRequestHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.apiCurrentUser(w, r)
+	if !ok {
+		return
+	}
+
+	requests, err := a.fetchColleagueRequests(user)
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	req, ok := apiColleagueRequestFromPath(w, r, requests)
+	if !ok {
+		return
+	}
+
+	if _, err = a.db.Exec("DELETE FROM colleague_requests WHERE request_id=$1", req.Id); err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DELETE /api/v1/colleagues/{id}
+func (a *App) apiRemoveColleagueHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.apiCurrentUser(w, r)
+	if !ok {
+		return
+	}
+
+	colleagueId, err := getIdFromPath(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+
+	if err = a.removeColleague(user, colleagueId); err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestKeepShareTargets(t *testing.T) {
+	// Users 2 and 3 accept notes from the sharer, 4 and 5 only accept notes from their colleagues
+	targets := []int32{2, 3}
+
+	tests := []struct {
+		name        string
+		share       pq.Int32Array
+		current     pq.Int32Array
+		want        pq.Int32Array
+		wantDropped bool
+	}{
+		{"nobody", pq.Int32Array{}, nil, pq.Int32Array{}, false},
+		{"every user accepts", pq.Int32Array{2, 3}, nil, pq.Int32Array{2, 3}, false},
+		{"colleagues only is dropped", pq.Int32Array{2, 4}, nil, pq.Int32Array{2}, true},
+		{"already shared stays", pq.Int32Array{2, 4}, pq.Int32Array{4}, pq.Int32Array{2, 4}, false},
+		{"only the new ones are dropped", pq.Int32Array{4, 5}, pq.Int32Array{4}, pq.Int32Array{4}, true},
+		{"removing someone isn't a drop", pq.Int32Array{2}, pq.Int32Array{2, 4}, pq.Int32Array{2}, false},
+	}
+
+	for _, tt := range tests {
+		got, dropped := keepShareTargets(tt.share, tt.current, targets)
+		if !reflect.DeepEqual(got, tt.want) || dropped != tt.wantDropped {
+			t.Errorf("%s: keepShareTargets(%v, %v) = %v, %v, want %v, %v", tt.name, tt.share, tt.current, got, dropped, tt.want, tt.wantDropped)
+		}
+	}
+}
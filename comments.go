@@ -0,0 +1,187 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* - Comment as it is sent by the api - */
+type apiNoteComment struct {
+	Id      int32     `json:"id"`
+	Author  int32     `json:"author"`
+	Content string    `json:"content"`
+	Date    time.Time `json:"date"`
+}
+
+/* - Body of a comment request - */
+type apiCommentRequest struct {
+	Content *string `json:"content"`
+}
+
+/*
+- Fetches the comments left on a note, oldest first
+Args:
+
+	noteId: note_id of the note
+
+return: list of comments or an error
+*/
+func (a *App) fetchNoteComments(noteId int32) ([]NoteComment, error) {
+	rows, err := a.db.Query("SELECT comment_id, note_id, comment_author, comment_content, comment_date FROM note_comments WHERE note_id=$1 ORDER BY comment_id",
+		noteId)
+	if err != nil {
+		return make([]NoteComment, 0), err
+	}
+	defer rows.Close()
+
+	comments := []NoteComment{}
+	for rows.Next() {
+		var c NoteComment
+		if e := rows.Scan(&c.Id, &c.NoteId, &c.Author, &c.Content, &c.Date); e != nil {
+			return make([]NoteComment, 0), e
+		}
+		comments = append(comments, c)
+	}
+
+	return comments, nil
+}
+
+/*
+- Leaves a comment on a note
+Args:
+
+	note: note being commented on
+	author: user leaving the comment (already checked with canCommentNote)
+	content: text of the comment, cut to CommentMaxLength
+
+return: the saved comment or an error
+*/
+func (a *App) insertNoteComment(note Note, author User, content string) (NoteComment, error) {
+	comment := NoteComment{
+		NoteId:  note.Id,
+		Author:  author.Id,
+		Content: truncateRunes(content, CommentMaxLength),
+		Date:    time.Now(),
+	}
+
+	err := a.db.QueryRow("INSERT INTO note_comments(note_id, comment_author, comment_content, comment_date) VALUES($1, $2, $3, $4) RETURNING comment_id",
+		comment.NoteId, comment.Author, comment.Content, comment.Date).Scan(&comment.Id)
+
+	return comment, err
+}
+
+func toApiNoteComment(c NoteComment) apiNoteComment {
+	return apiNoteComment{
+		Id:      c.Id,
+		Author:  c.Author,
+		Content: c.Content,
+		Date:    c.Date,
+	}
+}
+
+func (a *App) commentNoteHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAuthenticated(w, r) {
+		return
+	}
+
+	user, err := a.fetchCurrentUser(r)
+	checkInternalServerError(err, w)
+
+	noteId, err := getIdFromPath(r)
+	if err != nil {
+		http.Error(w, "invalid note id", http.StatusBadRequest)
+		return
+	}
+
+	note, err := a.fetchNote(noteId)
+	switch {
+	case err == sql.ErrNoRows:
+		setFlash(w, FlashDashboard, "That note no longer exists")
+		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		return
+	case err != nil:
+		checkInternalServerError(err, w)
+		return
+	case !canCommentNote(user, note):
+		forbidden(w)
+		return
+	}
+
+	history := "/notes/" + strconv.Itoa(int(note.Id)) + "/history"
+
+	content := strings.TrimSpace(r.FormValue("comment-content"))
+	if content == "" {
+		setFlash(w, FlashDashboard, "A comment can't be empty")
+		http.Redirect(w, r, history, http.StatusSeeOther)
+		return
+	}
+
+	_, err = a.insertNoteComment(note, user, content)
+	checkInternalServerError(err, w)
+
+	http.Redirect(w, r, history, http.StatusSeeOther)
+}
+
+// GET /api/v1/notes/{id}/comments
+func (a *App) apiListCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.apiCurrentUser(w, r)
+	if !ok {
+		return
+	}
+
+	note, ok := a.apiNoteFromPath(w, r, user)
+	if !ok {
+		return
+	}
+
+	comments, err := a.fetchNoteComments(note.Id)
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	apiComments := make([]apiNoteComment, 0, len(comments))
+	for _, c := range comments {
+		apiComments = append(apiComments, toApiNoteComment(c))
+	}
+
+	writeJSON(w, http.StatusOK, apiComments)
+}
+
+// POST /api/v1/notes/{id}/comments, body {"content": "..."}
+func (a *App) apiCreateCommentHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.apiCurrentUser(w, r)
+	if !ok {
+		return
+	}
+
+	note, ok := a.apiNoteFromPath(w, r, user)
+	if !ok {
+		return
+	}
+
+	if !canCommentNote(user, note) {
+		writeJSONError(w, http.StatusForbidden, "you can't comment on this note")
+		return
+	}
+
+	var req apiCommentRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.Content == nil || strings.TrimSpace(*req.Content) == "" {
+		writeJSONError(w, http.StatusBadRequest, "content is required")
+		return
+	}
+
+	comment, err := a.insertNoteComment(note, user, strings.TrimSpace(*req.Content))
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, toApiNoteComment(comment))
+}
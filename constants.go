@@ -1,5 +1,7 @@
 package main
 
+import "time"
+
 // PostgreSQl configuration if not passed as env variables
 const (
 	dbHost     = "localhost" //127.0.0.1
@@ -15,7 +17,7 @@ const (
 	argBindport = 1
 )
 
-// Note Flags
+// Built in workflow states, admins can add more (see workflow.go)
 const (
 	NoteFlagNote = iota
 	NoteFlagInProgress
@@ -25,9 +27,68 @@ const (
 	NoteFlagMax
 )
 
+// Note priorities, higher is more urgent
+const (
+	NotePriorityNone = iota
+	NotePriorityLow
+	NotePriorityMedium
+	NotePriorityHigh
+	NotePriorityMax
+)
+
+// Who can see a note, stored in note_visibility so they must not be renumbered
+const (
+	NoteVisibilityPrivate  = iota // only the owner (and anyone the note's notebook is shared with)
+	NoteVisibilityShared          // the users and groups the note is shared with
+	NoteVisibilityEveryone        // every user can view it, the users and groups it is shared with keep their roles
+	NoteVisibilityMax
+)
+
+// Api token scopes
+const (
+	TokenScopeReadOnly = iota
+	TokenScopeReadWrite
+	TokenScopeMax
+)
+
+// Sessions
+const (
+	SessionIdleTimeout   = 2 * time.Hour      // logged out after this long without a request
+	SessionMaxLifetime   = 7 * 24 * time.Hour // logged out this long after logging in
+	SessionSweepInterval = time.Minute        // how often expired sessions are deleted
+)
+
+// Trash
+const (
+	DefaultTrashRetention = 30 * 24 * time.Hour // override with TRASH_RETENTION_DAYS
+	TrashPurgeInterval    = time.Hour           // how often old notes are purged from the trash
+)
+
+// Public links
+const (
+	LinkPasswordAttempts = 5                // wrong passwords a link accepts...
+	LinkPasswordWindow   = 15 * time.Minute // ...in this long before it stops checking them
+	LinkUnlockLifetime   = time.Hour        // how long a correct password opens a link for
+	LinkViewWindow       = 24 * time.Hour   // opening a link again within this long isn't another view
+)
+
+// Dashboard and api note listing
+const (
+	DefaultPageSize = 25
+	MaxPageSize     = 100
+)
+
 // Global Constants
 const (
-	UsernameMaxLength = 255
-	PasswordMaxLength = 255
-	NoteNameMaxLength = 255
+	UsernameMaxLength     = 255
+	PasswordMaxLength     = 255
+	NoteNameMaxLength     = 255
+	TokenNameMaxLength    = 255
+	SearchNameMaxLength   = 255
+	TagNameMaxLength      = 64
+	StateNameMaxLength    = 64
+	CommentMaxLength      = 4096
+	GroupNameMaxLength    = 64
+	LinkLabelMaxLength    = 255
+	LinkPasswordMaxLength = 72 // the most bcrypt can hash
 )
@@ -2,9 +2,12 @@ package main
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"sort"
 	"time"
 
 	"github.com/lib/pq"
@@ -19,22 +22,159 @@ type User struct {
 
 /* - Entry from 'user_settings' table - */
 type UserSettings struct {
-	Id         int32
-	UserId     int32
-	Colleagues pq.Int32Array
+	Id             int32
+	UserId         int32
+	Colleagues     pq.Int32Array // accepted colleagues, always listed on both sides
+	ColleaguesOnly bool          // only colleagues can share notes with the user
+}
+
+/* - Entry from 'colleague_requests' table, an invitation that hasn't been answered yet - */
+type ColleagueRequest struct {
+	Id        int32
+	Requester int32
+	Recipient int32
+	Date      time.Time
 }
 
 /* - Entry from 'notes' table - */
 type Note struct {
+	Id             int32
 	Owner          int32
 	Share          pq.Int32Array
+	Visibility     int        // one of the NoteVisibility* constants
+	ShareRoles     ShareRoles // role of each user in Share
+	GroupShares    ShareRoles // share role of each group the note is shared with, by group_id
+	GroupRoles     ShareRoles // highest role each member of those groups gets through them, by user_id
 	Name           string
 	Date           time.Time
 	CompletionDate time.Time
+	Flag           int // state_id of the note's workflow state
+	Content        string
+	Deleted        sql.NullTime // set while the note is in the trash
+	Tags           pq.StringArray
+	Notebook       int32         // 0 if the note isn't in a notebook
+	NotebookShare  pq.Int32Array // users the note's notebook (or one of its parents) is shared with
+	DueDate        sql.NullTime  // not set if the note has no deadline
+	Priority       int
+	Assignees      pq.Int32Array // users the note is delegated to
+	Terminal       bool          // the note's workflow state is terminal (e.g. completed)
+}
+
+/* - Share role (NoteAccess*) of each user a note is shared with by user_id, or of each group by group_id - */
+type ShareRoles map[int32]int
+
+// Reads the JSON objects noteColumns selects from 'note_shares' and 'note_group_shares'
+func (roles *ShareRoles) Scan(src any) error {
+	*roles = ShareRoles{}
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), roles)
+	case []byte:
+		return json.Unmarshal(v, roles)
+	}
+	return fmt.Errorf("can't read share roles from %T", src)
+}
+
+/* - Entry from 'user_groups' table with its members - */
+type Group struct {
+	Id      int32
+	Name    string
+	Members []GroupMember
+}
+
+/* - Entry from 'group_members' table - */
+type GroupMember struct {
+	UserId int32
+	Admin  bool
+}
+
+/* - Entry from 'notebooks' table - */
+type Notebook struct {
+	Id     int32
+	Owner  int32
+	Parent int32 // 0 for a top level notebook
+	Name   string
+	Share  pq.Int32Array
+}
+
+/* - Entry from 'saved_searches' table - */
+type SavedSearch struct {
+	Id     int32
+	Owner  int32
+	Name   string
+	Query  string // dashboard query string without the leading '?'
+	Pinned bool
+	Share  pq.Int32Array
+}
+
+/* - Entry from 'note_revisions' table - */
+type NoteRevision struct {
+	Id             int32
+	NoteId         int32
+	Author         int32
+	Date           time.Time
+	Share          pq.Int32Array
+	Visibility     int
+	Name           string
+	CompletionDate time.Time
 	Flag           int
 	Content        string
 }
 
+/* - Entry from 'workflow_states' table - */
+type WorkflowState struct {
+	Id       int
+	Name     string
+	Colour   string // #rrggbb
+	Terminal bool
+	Position int
+}
+
+/* - Entry from 'note_assignments' table - */
+type NoteAssignment struct {
+	Id         int32
+	NoteId     int32
+	AssignedBy int32
+	AssignedTo pq.Int32Array
+	Date       time.Time
+}
+
+/* - Entry from 'note_comments' table - */
+type NoteComment struct {
+	Id      int32
+	NoteId  int32
+	Author  int32
+	Content string
+	Date    time.Time
+}
+
+/* - Entry from 'note_links' table, a public read-only link to a note - */
+type NoteLink struct {
+	Id         int32
+	NoteId     int32
+	CreatedBy  int32
+	Label      string
+	Hash       string
+	Password   string // bcrypt hash, empty if the link has no password
+	Created    time.Time
+	Expires    sql.NullTime // not set if the link never expires
+	Views      int
+	LastViewed sql.NullTime
+}
+
+/* - Entry from 'api_tokens' table - */
+type ApiToken struct {
+	Id       int32
+	UserId   int32
+	Name     string
+	Hash     string
+	Scope    int
+	Created  time.Time
+	LastUsed sql.NullTime
+}
+
 /*
 - Reads a sql script from a file and executes it on the database
 Args:
@@ -73,3 +213,96 @@ func (a *App) importData() {
 	}
 	defer file.Close()
 }
+
+/*
+- Runs the scripts in sqlScripts/migrations that haven't been applied yet, in name order.
+- Each script is recorded in 'schema_migrations' in the same transaction it runs in, so it is only ever applied once.
+return: nil or an error
+*/
+func (a *App) runMigrations() error {
+	_, err := a.db.Exec(`CREATE TABLE IF NOT EXISTS "schema_migrations" (
+		migration_name VARCHAR(255) PRIMARY KEY NOT NULL,
+		migration_applied TIMESTAMP NOT NULL DEFAULT NOW())`)
+	if err != nil {
+		return err
+	}
+
+	rows, err := a.db.Query("SELECT migration_name FROM schema_migrations")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	applied := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if e := rows.Scan(&name); e != nil {
+			return e
+		}
+		applied[name] = true
+	}
+
+	scripts, err := filepath.Glob("sqlScripts/migrations/*.sql")
+	if err != nil {
+		return err
+	}
+
+	for _, script := range pendingMigrations(scripts, applied) {
+		log.Printf("Applying migration %s\n", filepath.Base(script))
+		if err := a.applyMigration(script); err != nil {
+			return fmt.Errorf("%s: %w", script, err)
+		}
+	}
+
+	return nil
+}
+
+/*
+- Picks the migration scripts that still need to run
+Args:
+
+	scripts: paths of every migration script
+	applied: names of the scripts already recorded in 'schema_migrations'
+
+return: paths of the scripts that haven't been applied, in name order
+*/
+func pendingMigrations(scripts []string, applied map[string]bool) []string {
+	pending := []string{}
+	for _, script := range scripts {
+		if !applied[filepath.Base(script)] {
+			pending = append(pending, script)
+		}
+	}
+	sort.Strings(pending)
+	return pending
+}
+
+/*
+- Runs a migration script and records it, a script that fails leaves no trace and runs again on the next start
+Args:
+
+	scriptPath: path to the script
+
+return: nil or an error
+*/
+func (a *App) applyMigration(scriptPath string) error {
+	bytes, err := os.ReadFile(scriptPath)
+	if err != nil {
+		return err
+	}
+
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(string(bytes)); err != nil {
+		return err
+	}
+	if _, err = tx.Exec("INSERT INTO schema_migrations(migration_name) VALUES($1)", filepath.Base(scriptPath)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
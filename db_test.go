@@ -0,0 +1,38 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPendingMigrations(t *testing.T) {
+	scripts := []string{
+		"sqlScripts/migrations/002_sessions.sql",
+		"sqlScripts/migrations/001_api_tokens.sql",
+		"sqlScripts/migrations/003_note_revisions.sql",
+	}
+
+	tests := []struct {
+		name    string
+		applied map[string]bool
+		want    []string
+	}{
+		{"new database", map[string]bool{}, []string{
+			"sqlScripts/migrations/001_api_tokens.sql",
+			"sqlScripts/migrations/002_sessions.sql",
+			"sqlScripts/migrations/003_note_revisions.sql",
+		}},
+		{"only new scripts run", map[string]bool{"001_api_tokens.sql": true, "002_sessions.sql": true},
+			[]string{"sqlScripts/migrations/003_note_revisions.sql"}},
+		{"a gap still runs", map[string]bool{"001_api_tokens.sql": true, "003_note_revisions.sql": true},
+			[]string{"sqlScripts/migrations/002_sessions.sql"}},
+		{"up to date", map[string]bool{"001_api_tokens.sql": true, "002_sessions.sql": true, "003_note_revisions.sql": true},
+			[]string{}},
+	}
+
+	for _, tt := range tests {
+		if got := pendingMigrations(scripts, tt.applied); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: pendingMigrations = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
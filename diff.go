@@ -0,0 +1,118 @@
+package main
+
+import "strings"
+
+// Kinds of line in a diff
+const (
+	DiffSame = iota
+	DiffAdded
+	DiffRemoved
+)
+
+/* - A single line of a line-level diff - */
+type DiffLine struct {
+	Kind int
+	Text string
+}
+
+// Largest LCS table diffLines builds (lines of the old text times lines of the new text, after the lines both
+// texts start and end with are left out). Bigger changes are shown as the old lines removed and the new ones added
+const diffMaxCells = 1 << 20
+
+/*
+- Works out the line-level differences between two texts using the longest common subsequence
+Args:
+
+	before: old text
+	after: new text
+
+return: every line of both texts, marked as same, added or removed
+*/
+func diffLines(before, after string) []DiffLine {
+	a := strings.Split(strings.ReplaceAll(before, "\r\n", "\n"), "\n")
+	b := strings.Split(strings.ReplaceAll(after, "\r\n", "\n"), "\n")
+
+	// Lines both texts start or end with are the same whatever is between them
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	diff := make([]DiffLine, 0, len(a)+len(b)-prefix-suffix)
+	for _, line := range a[:prefix] {
+		diff = append(diff, DiffLine{Kind: DiffSame, Text: line})
+	}
+	diff = append(diff, diffMiddle(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		diff = append(diff, DiffLine{Kind: DiffSame, Text: line})
+	}
+
+	return diff
+}
+
+/*
+- Diffs the lines between the common start and end of two texts
+Args:
+
+	a: old lines
+	b: new lines
+
+return: the lines marked as same, added or removed
+*/
+func diffMiddle(a, b []string) []DiffLine {
+	diff := make([]DiffLine, 0, len(a)+len(b))
+
+	// The table would take too much memory, so don't look for lines that moved
+	if len(a)*len(b) > diffMaxCells {
+		for _, line := range a {
+			diff = append(diff, DiffLine{Kind: DiffRemoved, Text: line})
+		}
+		for _, line := range b {
+			diff = append(diff, DiffLine{Kind: DiffAdded, Text: line})
+		}
+		return diff
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			diff = append(diff, DiffLine{Kind: DiffSame, Text: a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, DiffLine{Kind: DiffRemoved, Text: a[i]})
+			i++
+		default:
+			diff = append(diff, DiffLine{Kind: DiffAdded, Text: b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		diff = append(diff, DiffLine{Kind: DiffRemoved, Text: a[i]})
+	}
+	for ; j < len(b); j++ {
+		diff = append(diff, DiffLine{Kind: DiffAdded, Text: b[j]})
+	}
+
+	return diff
+}
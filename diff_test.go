@@ -0,0 +1,47 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name          string
+		before, after string
+		want          []DiffLine
+	}{
+		{"unchanged", "a\nb", "a\nb", []DiffLine{{DiffSame, "a"}, {DiffSame, "b"}}},
+		{"added", "a\nc", "a\nb\nc", []DiffLine{{DiffSame, "a"}, {DiffAdded, "b"}, {DiffSame, "c"}}},
+		{"removed", "a\nb\nc", "a\nc", []DiffLine{{DiffSame, "a"}, {DiffRemoved, "b"}, {DiffSame, "c"}}},
+		{"changed", "a\nb\nc", "a\nx\nc", []DiffLine{{DiffSame, "a"}, {DiffRemoved, "b"}, {DiffAdded, "x"}, {DiffSame, "c"}}},
+		{"from empty", "", "a", []DiffLine{{DiffRemoved, ""}, {DiffAdded, "a"}}},
+		{"windows line endings", "a\r\nb", "a\nb", []DiffLine{{DiffSame, "a"}, {DiffSame, "b"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diffLines(tt.before, tt.after); !slices.Equal(got, tt.want) {
+				t.Errorf("diffLines(%q, %q) = %v, want %v", tt.before, tt.after, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffLinesLargeChange(t *testing.T) {
+	// Too big for the LCS table, every changed line is still listed
+	var before, after []string
+	for i := 0; i < 2000; i++ {
+		before = append(before, "old "+strings.Repeat("x", i%7))
+		after = append(after, "new "+strings.Repeat("x", i%7))
+	}
+
+	diff := diffLines("same\n"+strings.Join(before, "\n"), "same\n"+strings.Join(after, "\n"))
+	if len(diff) != 4001 || diff[0] != (DiffLine{DiffSame, "same"}) {
+		t.Fatalf("got %d lines starting with %v", len(diff), diff[0])
+	}
+	if diff[1].Kind != DiffRemoved || diff[2001].Kind != DiffAdded {
+		t.Errorf("expected the removed lines followed by the added ones, got %v and %v", diff[1], diff[2001])
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Flash message names, each one is stored in its own cookie
+const (
+	FlashLogin     = "login"
+	FlashRegister  = "register"
+	FlashDashboard = "dashboard"
+)
+
+const flashCookiePrefix = "flash-"
+
+// Key used to sign flash cookies so they can't be forged for another client
+var flashKey []byte
+
+/*
+- Sets up the key used to sign flash messages.
+- Uses FLASH_SECRET if set, otherwise a random key (flashes don't survive a restart)
+*/
+func setupFlash() {
+	if secret := os.Getenv("FLASH_SECRET"); secret != "" {
+		flashKey = []byte(secret)
+		return
+	}
+
+	flashKey = make([]byte, 32)
+	if _, err := rand.Read(flashKey); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func signFlash(name, value string) string {
+	mac := hmac.New(sha256.New, flashKey)
+	mac.Write([]byte(name + ":" + value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+/*
+- Stores a message for the next page the client loads
+Args:
+
+	w: http response writer
+	name: flash name (e.g. FlashLogin)
+	msg: message to show
+*/
+func setFlash(w http.ResponseWriter, name, msg string) {
+	value := base64.RawURLEncoding.EncodeToString([]byte(msg))
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     flashCookiePrefix + name,
+		Value:    value + "." + signFlash(name, value),
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+/*
+- Reads a flash message and clears it so it is only shown once
+Args:
+
+	w: http response writer
+	r: http request
+	name: flash name (e.g. FlashLogin)
+
+return: the message or an empty string if there isn't one (or it was tampered with)
+*/
+func popFlash(w http.ResponseWriter, r *http.Request, name string) string {
+	cookie, err := r.Cookie(flashCookiePrefix + name)
+	if err != nil {
+		return ""
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:   flashCookiePrefix + name,
+		Value:  "",
+		Path:   "/",
+		MaxAge: -1,
+	})
+
+	value, sig, ok := strings.Cut(cookie.Value, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(signFlash(name, value))) {
+		return ""
+	}
+
+	msg, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return ""
+	}
+
+	return string(msg)
+}
@@ -0,0 +1,804 @@
+package main
+
+import (
+	"database/sql"
+	"html/template"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+type GroupsData struct {
+	CurrentUser User
+	Groups      []Group // groups the current user is in
+	Users       []User
+	FlashMsg    string
+}
+
+/* - Group as it is sent by the api - */
+type apiGroup struct {
+	Id      int32            `json:"id"`
+	Name    string           `json:"name"`
+	Members []apiGroupMember `json:"members"`
+}
+
+/* - Group member as it is sent and received by the api - */
+type apiGroupMember struct {
+	Id    int32 `json:"id"`
+	Admin bool  `json:"admin"`
+}
+
+/* - Body of a group create or update request, fields that are left out are not changed - */
+type apiGroupRequest struct {
+	Name    *string           `json:"name"`
+	Members *[]apiGroupMember `json:"members"`
+}
+
+/*
+- Fetches every group and its members
+return: list of groups ordered by name or an error
+*/
+func (a *App) fetchGroups() ([]Group, error) {
+	rows, err := a.db.Query("SELECT g.group_id, g.group_name, gm.user_id, COALESCE(gm.member_admin, FALSE) FROM user_groups g " +
+		"LEFT JOIN group_members gm ON gm.group_id=g.group_id ORDER BY lower(g.group_name), g.group_id, gm.user_id")
+	if err != nil {
+		return make([]Group, 0), err
+	}
+	defer rows.Close()
+
+	groups := []Group{}
+	for rows.Next() {
+		var (
+			g      Group
+			userId sql.NullInt32
+			admin  bool
+		)
+		if e := rows.Scan(&g.Id, &g.Name, &userId, &admin); e != nil {
+			return make([]Group, 0), e
+		}
+
+		// One row per member, the group is only added on its first row
+		if len(groups) == 0 || groups[len(groups)-1].Id != g.Id {
+			g.Members = []GroupMember{}
+			groups = append(groups, g)
+		}
+		if userId.Valid {
+			last := &groups[len(groups)-1]
+			last.Members = append(last.Members, GroupMember{UserId: userId.Int32, Admin: admin})
+		}
+	}
+
+	return groups, nil
+}
+
+/*
+- Gets the groups a user is a member of
+*/
+func userGroups(user User, groups []Group) []Group {
+	mine := []Group{}
+	for _, g := range groups {
+		if isGroupMember(user, g) {
+			mine = append(mine, g)
+		}
+	}
+	return mine
+}
+
+func isGroupMember(user User, group Group) bool {
+	return slices.ContainsFunc(group.Members, func(m GroupMember) bool { return m.UserId == user.Id })
+}
+
+// Group admins can rename the group, change its members and delete it
+func isGroupAdmin(user User, group Group) bool {
+	return slices.ContainsFunc(group.Members, func(m GroupMember) bool { return m.UserId == user.Id && m.Admin })
+}
+
+/*
+- Checks a new member list for a group
+Args:
+
+	members: proposed members
+	users: every user
+
+return: the members without duplicates, or an error message
+*/
+func checkGroupMembers(members []GroupMember, users []User) ([]GroupMember, string) {
+	checked := []GroupMember{}
+	hasAdmin := false
+	for _, m := range members {
+		if !slices.ContainsFunc(users, func(u User) bool { return u.Id == m.UserId }) {
+			return nil, "user " + strconv.Itoa(int(m.UserId)) + " doesn't exist"
+		}
+		if slices.ContainsFunc(checked, func(c GroupMember) bool { return c.UserId == m.UserId }) {
+			continue
+		}
+		checked = append(checked, m)
+		hasAdmin = hasAdmin || m.Admin
+	}
+
+	if !hasAdmin {
+		return nil, "a group needs at least one admin"
+	}
+	return checked, ""
+}
+
+/*
+- Checks a group name, names are unique ignoring case
+Args:
+
+	name: proposed name
+	groupId: group being renamed, 0 for a new group
+	groups: every group
+
+return: the trimmed name, or an error message
+*/
+func checkGroupName(name string, groupId int32, groups []Group) (string, string) {
+	name = strings.TrimSpace(name)
+	name = truncateRunes(name, GroupNameMaxLength)
+	if name == "" {
+		return "", "a group needs a name"
+	}
+
+	for _, g := range groups {
+		if g.Id != groupId && strings.EqualFold(g.Name, name) {
+			return "", "there is already a group called '" + g.Name + "'"
+		}
+	}
+	return name, ""
+}
+
+/*
+- Creates a group or replaces the name and members of an existing one
+Args:
+
+	group: group to save, a new group is created if group.Id is 0
+	members: members already checked with checkGroupMembers
+
+return: the group's id or an error
+*/
+func (a *App) saveGroup(group Group, members []GroupMember) (int32, error) {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if group.Id == 0 {
+		err = tx.QueryRow("INSERT INTO user_groups(group_name) VALUES($1) RETURNING group_id", group.Name).Scan(&group.Id)
+	} else {
+		_, err = tx.Exec("UPDATE user_groups SET group_name=$1 WHERE group_id=$2", group.Name, group.Id)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// Access to shared notes follows the members, so nothing else changes when they do
+	if _, err = tx.Exec("DELETE FROM group_members WHERE group_id=$1", group.Id); err != nil {
+		return 0, err
+	}
+	for _, m := range members {
+		_, err = tx.Exec("INSERT INTO group_members(group_id, user_id, member_admin) VALUES($1, $2, $3)",
+			group.Id, m.UserId, m.Admin)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return group.Id, tx.Commit()
+}
+
+/*
+- Gets the groups picked in the share fieldset and the role of each one
+Args:
+
+	formIdPrefix: input name prefix (e.g. 'create')
+	groups: groups the current user can share with
+	r: http request
+
+return: role of each picked group, groups without a valid role are viewers
+*/
+func getGroupShares(formIdPrefix string, groups []Group, r *http.Request) ShareRoles {
+	r.ParseForm()
+	shares := ShareRoles{}
+	for _, g := range groups {
+		if !slices.Contains(r.Form[formIdPrefix+"-groups"], strconv.Itoa(int(g.Id))) {
+			continue
+		}
+
+		role, err := strconv.Atoi(r.FormValue(formIdPrefix + "-group-role-" + strconv.Itoa(int(g.Id))))
+		if err != nil || !isValidShareRole(role) {
+			role = NoteAccessViewer
+		}
+		shares[g.Id] = role
+	}
+	return shares
+}
+
+/*
+- Merges the group shares someone picked with the ones they couldn't change, a note stays shared with
+groups the user isn't in
+Args:
+
+	current: groups the note is shared with now
+	edited: groups picked by the user
+	groups: groups the user is in
+
+return: the note's new group shares
+*/
+func mergeGroupShares(current, edited ShareRoles, groups []Group) ShareRoles {
+	merged := ShareRoles{}
+	for id, role := range current {
+		if !slices.ContainsFunc(groups, func(g Group) bool { return g.Id == id }) {
+			merged[id] = role
+		}
+	}
+	for id, role := range edited {
+		merged[id] = role
+	}
+	return merged
+}
+
+/*
+- Works out the role each member of a note's groups gets through them, what noteColumns selects as GroupRoles
+Args:
+
+	shares: groups the note is shared with and their roles
+	groups: every group
+
+return: highest role of each member by user_id
+*/
+func groupRoles(shares ShareRoles, groups []Group) ShareRoles {
+	roles := ShareRoles{}
+	for _, g := range groups {
+		if _, ok := shares[g.Id]; !ok {
+			continue
+		}
+		for _, m := range g.Members {
+			roles[m.UserId] = max(roles[m.UserId], shares.role(g.Id))
+		}
+	}
+	return roles
+}
+
+/*
+- Gets the group in the request path if the current user is in it
+Args:
+
+	w: http response writer
+	r: http request
+	user: current user
+	groups: every group
+	admin: the user must also be an admin of the group
+
+return: the group and true, or false if a response has already been sent
+*/
+func groupFromPath(w http.ResponseWriter, r *http.Request, user User, groups []Group, admin bool) (Group, bool) {
+	id, err := getIdFromPath(r)
+	if err != nil {
+		http.Error(w, "invalid group id", http.StatusBadRequest)
+		return Group{}, false
+	}
+
+	for _, g := range groups {
+		if g.Id == id {
+			if !isGroupMember(user, g) || (admin && !isGroupAdmin(user, g)) {
+				forbidden(w)
+				return Group{}, false
+			}
+			return g, true
+		}
+	}
+
+	http.NotFound(w, r)
+	return Group{}, false
+}
+
+/*
+- Checks that the users being added to a group accept notes from the admin adding them, notes shared with a
+group reach every member so a user who only accepts notes from colleagues can't be added by anyone else
+Args:
+
+	user: admin changing the group
+	members: the group's new members
+	current: the group's members now
+
+return: an error message if someone can't be added, or an error
+*/
+func (a *App) checkGroupInvites(user User, members, current []GroupMember) (string, error) {
+	targets, err := a.fetchShareTargets(user)
+	if err != nil {
+		return "", err
+	}
+	targets = append(targets, user.Id)
+
+	ids, currentIds := pq.Int32Array{}, pq.Int32Array{}
+	for _, m := range members {
+		ids = append(ids, m.UserId)
+	}
+	for _, m := range current {
+		currentIds = append(currentIds, m.UserId)
+	}
+
+	if _, dropped := keepShareTargets(ids, currentIds, targets); dropped {
+		return "some of these users only accept notes from their colleagues, invite them as colleagues first", nil
+	}
+	return "", nil
+}
+
+/*
+- Saves a change to a group's members made from the groups page, problems are shown as a flash message
+Args:
+
+	w: http response writer
+	r: http request
+	user: admin changing the group
+	group: group being changed
+	members: the group's new members
+*/
+func (a *App) saveGroupMembers(w http.ResponseWriter, r *http.Request, user User, group Group, members []GroupMember) {
+	users, err := a.fetchUsersExclude(User{})
+	checkInternalServerError(err, w)
+
+	members, msg := checkGroupMembers(members, users)
+	if msg == "" {
+		msg, err = a.checkGroupInvites(user, members, group.Members)
+		checkInternalServerError(err, w)
+	}
+	if msg != "" {
+		setFlash(w, FlashDashboard, "Couldn't change '"+group.Name+"': "+msg)
+		http.Redirect(w, r, "/groups", http.StatusSeeOther)
+		return
+	}
+
+	_, err = a.saveGroup(group, members)
+	checkInternalServerError(err, w)
+
+	http.Redirect(w, r, "/groups", http.StatusSeeOther)
+}
+
+func (a *App) groupsHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAuthenticated(w, r) {
+		return
+	}
+
+	user, err := a.fetchCurrentUser(r)
+	checkInternalServerError(err, w)
+
+	groups, err := a.fetchGroups()
+	checkInternalServerError(err, w)
+
+	users, err := a.fetchUsersExclude(User{})
+	checkInternalServerError(err, w)
+
+	tmplData := GroupsData{
+		CurrentUser: user,
+		Groups:      userGroups(user, groups),
+		Users:       users,
+		FlashMsg:    popFlash(w, r, FlashDashboard),
+	}
+
+	executeTemplate(w, "groups.html", "web/groups.html",
+		template.FuncMap{
+			"getUserName": func(id int32) string {
+				i := slices.IndexFunc(users, func(u User) bool { return u.Id == id })
+				if i == -1 {
+					return ""
+				}
+				return users[i].Username
+			},
+			"isGroupAdmin": func(group Group) bool {
+				return isGroupAdmin(user, group)
+			},
+			"isGroupMember": func(group Group, id int32) bool {
+				return isGroupMember(User{Id: id}, group)
+			},
+		},
+		tmplData)
+}
+
+func (a *App) createGroupHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAuthenticated(w, r) {
+		return
+	}
+
+	user, err := a.fetchCurrentUser(r)
+	checkInternalServerError(err, w)
+
+	groups, err := a.fetchGroups()
+	checkInternalServerError(err, w)
+
+	name, msg := checkGroupName(r.FormValue("group-create-name"), 0, groups)
+	if msg != "" {
+		setFlash(w, FlashDashboard, "Couldn't create the group: "+msg)
+		http.Redirect(w, r, "/groups", http.StatusSeeOther)
+		return
+	}
+
+	// Whoever creates a group is its first admin
+	_, err = a.saveGroup(Group{Name: name}, []GroupMember{{UserId: user.Id, Admin: true}})
+	checkInternalServerError(err, w)
+
+	http.Redirect(w, r, "/groups", http.StatusSeeOther)
+}
+
+func (a *App) editGroupHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAuthenticated(w, r) {
+		return
+	}
+
+	user, err := a.fetchCurrentUser(r)
+	checkInternalServerError(err, w)
+
+	groups, err := a.fetchGroups()
+	checkInternalServerError(err, w)
+
+	group, ok := groupFromPath(w, r, user, groups, true)
+	if !ok {
+		return
+	}
+
+	name, msg := checkGroupName(r.FormValue("group-edit-name"), group.Id, groups)
+	if msg != "" {
+		setFlash(w, FlashDashboard, "Couldn't rename '"+group.Name+"': "+msg)
+		http.Redirect(w, r, "/groups", http.StatusSeeOther)
+		return
+	}
+	group.Name = name
+
+	_, err = a.saveGroup(group, group.Members)
+	checkInternalServerError(err, w)
+
+	http.Redirect(w, r, "/groups", http.StatusSeeOther)
+}
+
+/*
+- Deletes a group, notes shared with it are no longer shared with its members
+*/
+func (a *App) deleteGroupHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAuthenticated(w, r) {
+		return
+	}
+
+	user, err := a.fetchCurrentUser(r)
+	checkInternalServerError(err, w)
+
+	groups, err := a.fetchGroups()
+	checkInternalServerError(err, w)
+
+	group, ok := groupFromPath(w, r, user, groups, true)
+	if !ok {
+		return
+	}
+
+	_, err = a.db.Exec("DELETE FROM user_groups WHERE group_id=$1", group.Id)
+	checkInternalServerError(err, w)
+
+	http.Redirect(w, r, "/groups", http.StatusSeeOther)
+}
+
+func (a *App) addGroupMemberHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAuthenticated(w, r) {
+		return
+	}
+
+	user, err := a.fetchCurrentUser(r)
+	checkInternalServerError(err, w)
+
+	groups, err := a.fetchGroups()
+	checkInternalServerError(err, w)
+
+	group, ok := groupFromPath(w, r, user, groups, true)
+	if !ok {
+		return
+	}
+
+	memberId, err := strconv.Atoi(r.FormValue("group-member"))
+	if err != nil {
+		http.Error(w, "invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	// Adding someone who is already a member just changes whether they are an admin
+	members := slices.DeleteFunc(slices.Clone(group.Members), func(m GroupMember) bool { return m.UserId == int32(memberId) })
+	members = append(members, GroupMember{UserId: int32(memberId), Admin: r.FormValue("group-member-admin") != ""})
+
+	a.saveGroupMembers(w, r, user, group, members)
+}
+
+/*
+- Makes a member an admin of the group or takes it away
+*/
+func (a *App) editGroupMemberHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAuthenticated(w, r) {
+		return
+	}
+
+	user, err := a.fetchCurrentUser(r)
+	checkInternalServerError(err, w)
+
+	groups, err := a.fetchGroups()
+	checkInternalServerError(err, w)
+
+	group, ok := groupFromPath(w, r, user, groups, true)
+	if !ok {
+		return
+	}
+
+	memberId, err := strconv.Atoi(mux.Vars(r)["user"])
+	if err != nil {
+		http.Error(w, "invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	members := slices.Clone(group.Members)
+	for i := range members {
+		if members[i].UserId == int32(memberId) {
+			members[i].Admin = r.FormValue("group-member-admin") != ""
+		}
+	}
+
+	a.saveGroupMembers(w, r, user, group, members)
+}
+
+/*
+- Removes a member from a group, admins can remove anyone and members can leave
+*/
+func (a *App) removeGroupMemberHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAuthenticated(w, r) {
+		return
+	}
+
+	user, err := a.fetchCurrentUser(r)
+	checkInternalServerError(err, w)
+
+	groups, err := a.fetchGroups()
+	checkInternalServerError(err, w)
+
+	memberId, err := strconv.Atoi(mux.Vars(r)["user"])
+	if err != nil {
+		http.Error(w, "invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	group, ok := groupFromPath(w, r, user, groups, int32(memberId) != user.Id)
+	if !ok {
+		return
+	}
+
+	members := slices.DeleteFunc(slices.Clone(group.Members), func(m GroupMember) bool { return m.UserId == int32(memberId) })
+
+	// The last member leaving deletes the group rather than leaving it without an admin
+	if len(members) == 0 {
+		_, err = a.db.Exec("DELETE FROM user_groups WHERE group_id=$1", group.Id)
+		checkInternalServerError(err, w)
+
+		http.Redirect(w, r, "/groups", http.StatusSeeOther)
+		return
+	}
+
+	a.saveGroupMembers(w, r, user, group, members)
+}
+
+func toApiGroup(g Group) apiGroup {
+	members := make([]apiGroupMember, 0, len(g.Members))
+	for _, m := range g.Members {
+		members = append(members, apiGroupMember{Id: m.UserId, Admin: m.Admin})
+	}
+	return apiGroup{Id: g.Id, Name: g.Name, Members: members}
+}
+
+/*
+- Applies a group request to a group, problems are sent to the client
+Args:
+
+	w: http response writer
+	user: admin changing the group
+	req: decoded request body
+	group: group to change, a new group if group.Id is 0
+	groups: every group
+
+return: the group's new members and true, or false if a response has already been sent
+*/
+func (a *App) applyGroupRequest(w http.ResponseWriter, user User, req apiGroupRequest, group *Group, groups []Group) ([]GroupMember, bool) {
+	if req.Name != nil {
+		name, msg := checkGroupName(*req.Name, group.Id, groups)
+		if msg != "" {
+			writeJSONError(w, http.StatusBadRequest, msg)
+			return nil, false
+		}
+		group.Name = name
+	}
+
+	members := group.Members
+	if req.Members != nil {
+		members = []GroupMember{}
+		for _, m := range *req.Members {
+			members = append(members, GroupMember{UserId: m.Id, Admin: m.Admin})
+		}
+	}
+
+	users, err := a.fetchUsersExclude(User{})
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return nil, false
+	}
+
+	members, msg := checkGroupMembers(members, users)
+	if msg != "" {
+		writeJSONError(w, http.StatusBadRequest, msg)
+		return nil, false
+	}
+
+	msg, err = a.checkGroupInvites(user, members, group.Members)
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return nil, false
+	}
+	if msg != "" {
+		writeJSONError(w, http.StatusBadRequest, msg)
+		return nil, false
+	}
+
+	return members, true
+}
+
+// GET /api/v1/groups
+func (a *App) apiListGroupsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.apiCurrentUser(w, r)
+	if !ok {
+		return
+	}
+
+	groups, err := a.fetchGroups()
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	apiGroups := []apiGroup{}
+	for _, g := range userGroups(user, groups) {
+		apiGroups = append(apiGroups, toApiGroup(g))
+	}
+
+	writeJSON(w, http.StatusOK, apiGroups)
+}
+
+// POST /api/v1/groups, body {"name": "...", "members": [{"id": 2, "admin": false}]}
+func (a *App) apiCreateGroupHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.apiCurrentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req apiGroupRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.Name == nil {
+		writeJSONError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+
+	groups, err := a.fetchGroups()
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	// The creator is an admin unless the request says otherwise
+	group := Group{Members: []GroupMember{{UserId: user.Id, Admin: true}}}
+	if req.Members != nil && !slices.ContainsFunc(*req.Members, func(m apiGroupMember) bool { return m.Id == user.Id }) {
+		*req.Members = append(*req.Members, apiGroupMember{Id: user.Id, Admin: true})
+	}
+
+	members, ok := a.applyGroupRequest(w, user, req, &group, groups)
+	if !ok {
+		return
+	}
+
+	group.Id, err = a.saveGroup(group, members)
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+	group.Members = members
+
+	writeJSON(w, http.StatusCreated, toApiGroup(group))
+}
+
+// PUT /api/v1/groups/{id}, body {"name": "...", "members": [...]}, members replaces every member
+func (a *App) apiUpdateGroupHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.apiCurrentUser(w, r)
+	if !ok {
+		return
+	}
+
+	groups, err := a.fetchGroups()
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	group, ok := apiGroupFromPath(w, r, user, groups)
+	if !ok {
+		return
+	}
+
+	var req apiGroupRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	members, ok := a.applyGroupRequest(w, user, req, &group, groups)
+	if !ok {
+		return
+	}
+
+	if _, err = a.saveGroup(group, members); err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+	group.Members = members
+
+	writeJSON(w, http.StatusOK, toApiGroup(group))
+}
+
+// DELETE /api/v1/groups/{id}
+func (a *App) apiDeleteGroupHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.apiCurrentUser(w, r)
+	if !ok {
+		return
+	}
+
+	groups, err := a.fetchGroups()
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	group, ok := apiGroupFromPath(w, r, user, groups)
+	if !ok {
+		return
+	}
+
+	if _, err = a.db.Exec("DELETE FROM user_groups WHERE group_id=$1", group.Id); err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+/*
+- Gets the group in the request path if the current user is one of its admins
+return: the group and true, or false if an error has already been sent
+*/
+func apiGroupFromPath(w http.ResponseWriter, r *http.Request, user User, groups []Group) (Group, bool) {
+	id, err := getIdFromPath(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid group id")
+		return Group{}, false
+	}
+
+	for _, g := range groups {
+		if g.Id != id {
+			continue
+		}
+		if !isGroupMember(user, g) {
+			break
+		}
+		if !isGroupAdmin(user, g) {
+			writeJSONError(w, http.StatusForbidden, "only the group's admins can change it")
+			return Group{}, false
+		}
+		return g, true
+	}
+
+	// Groups the user isn't in are hidden from them
+	writeJSONError(w, http.StatusNotFound, "group not found")
+	return Group{}, false
+}
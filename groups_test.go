@@ -0,0 +1,111 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testGroups = []Group{
+	{Id: 1, Name: "Ops", Members: []GroupMember{{UserId: 1, Admin: true}, {UserId: 2}}},
+	{Id: 2, Name: "Dev", Members: []GroupMember{{UserId: 2, Admin: true}, {UserId: 3}}},
+	{Id: 3, Name: "Empty"},
+}
+
+func TestGroupRoles(t *testing.T) {
+	tests := []struct {
+		name   string
+		shares ShareRoles
+		want   ShareRoles
+	}{
+		{"not shared", ShareRoles{}, ShareRoles{}},
+		{"every member gets the group's role", ShareRoles{1: NoteAccessEditor},
+			ShareRoles{1: NoteAccessEditor, 2: NoteAccessEditor}},
+		{"a member of two groups gets the higher role", ShareRoles{1: NoteAccessCommenter, 2: NoteAccessCoOwner},
+			ShareRoles{1: NoteAccessCommenter, 2: NoteAccessCoOwner, 3: NoteAccessCoOwner}},
+		{"an invalid role is read as viewer", ShareRoles{2: NoteAccessOwner},
+			ShareRoles{2: NoteAccessViewer, 3: NoteAccessViewer}},
+		{"deleted and empty groups give nothing", ShareRoles{3: NoteAccessEditor, 99: NoteAccessEditor}, ShareRoles{}},
+	}
+
+	for _, tt := range tests {
+		if got := groupRoles(tt.shares, testGroups); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: groupRoles(%v) = %v, want %v", tt.name, tt.shares, got, tt.want)
+		}
+	}
+}
+
+func TestMergeGroupShares(t *testing.T) {
+	// The user is only in Ops, so the note's share with Dev can't be changed by them
+	userGroups := testGroups[:1]
+
+	tests := []struct {
+		name    string
+		current ShareRoles
+		edited  ShareRoles
+		want    ShareRoles
+	}{
+		{"add a group", ShareRoles{}, ShareRoles{1: NoteAccessEditor}, ShareRoles{1: NoteAccessEditor}},
+		{"remove a group", ShareRoles{1: NoteAccessEditor}, ShareRoles{}, ShareRoles{}},
+		{"other groups are kept", ShareRoles{1: NoteAccessEditor, 2: NoteAccessViewer}, ShareRoles{},
+			ShareRoles{2: NoteAccessViewer}},
+		{"change a role", ShareRoles{1: NoteAccessViewer, 2: NoteAccessViewer}, ShareRoles{1: NoteAccessCoOwner},
+			ShareRoles{1: NoteAccessCoOwner, 2: NoteAccessViewer}},
+	}
+
+	for _, tt := range tests {
+		if got := mergeGroupShares(tt.current, tt.edited, userGroups); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: mergeGroupShares(%v, %v) = %v, want %v", tt.name, tt.current, tt.edited, got, tt.want)
+		}
+	}
+}
+
+func TestCheckGroupMembers(t *testing.T) {
+	users := []User{{Id: 1}, {Id: 2}, {Id: 3}}
+
+	tests := []struct {
+		name    string
+		members []GroupMember
+		want    []GroupMember
+		wantErr string
+	}{
+		{"valid", []GroupMember{{UserId: 1, Admin: true}, {UserId: 2}},
+			[]GroupMember{{UserId: 1, Admin: true}, {UserId: 2}}, ""},
+		{"duplicates keep the first", []GroupMember{{UserId: 1, Admin: true}, {UserId: 1}},
+			[]GroupMember{{UserId: 1, Admin: true}}, ""},
+		{"no admin", []GroupMember{{UserId: 1}, {UserId: 2}}, nil, "a group needs at least one admin"},
+		{"empty", []GroupMember{}, nil, "a group needs at least one admin"},
+		{"unknown user", []GroupMember{{UserId: 1, Admin: true}, {UserId: 9}}, nil, "user 9 doesn't exist"},
+	}
+
+	for _, tt := range tests {
+		got, errMsg := checkGroupMembers(tt.members, users)
+		if errMsg != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: checkGroupMembers = %v, %q, want %v, %q", tt.name, got, errMsg, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestCheckGroupName(t *testing.T) {
+	long := strings.Repeat("é", GroupNameMaxLength+5)
+
+	tests := []struct {
+		name    string
+		groupId int32
+		want    string
+		wantErr string
+	}{
+		{"  Support  ", 0, "Support", ""},
+		{"   ", 0, "", "a group needs a name"},
+		{"ops", 0, "", "there is already a group called 'Ops'"},
+		{"OPS", 1, "OPS", ""},
+		{long, 0, strings.Repeat("é", GroupNameMaxLength), ""},
+	}
+
+	for _, tt := range tests {
+		got, errMsg := checkGroupName(tt.name, tt.groupId, testGroups)
+		if got != tt.want || errMsg != tt.wantErr {
+			t.Errorf("checkGroupName(%q, %d) = %q, %q, want %q, %q", tt.name, tt.groupId, got, errMsg, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"database/sql"
 	"html/template"
 	"net/http"
+	"net/url"
+	"slices"
 	"strconv"
-	"strings"
+	"time"
 
+	"github.com/gorilla/mux"
 	"github.com/icza/session"
+	"github.com/lib/pq"
 )
 
 type DashboardData struct {
@@ -14,6 +19,108 @@ type DashboardData struct {
 	CurrentUserSettings UserSettings
 	Users               []User
 	Notes               []Note
+	Highlights          map[int32]*NoteHighlight
+	Search              SearchQuery
+	Page                PageQuery
+	TotalNotes          int
+	NextPageUrl         string
+	PrevPageUrl         string
+	SearchError         string
+	SavedSearches       []SavedSearchView
+	DashboardViews      []SavedSearchView // built in views such as overdue notes
+	Workflow            Workflow
+	IsAdmin             bool
+	FlashMsg            string
+	Tokens              []ApiToken
+	Trash               []Note
+	TagCounts           []TagCount
+	NotebookTree        []NotebookNode
+	OwnedNotebooks      []Notebook
+	Groups              []Group // groups the current user is in, notes can be shared with them
+	ColleagueRequests   []ColleagueRequest
+}
+
+/* - Search filters for the dashboard, carried in the query string so each request has its own - */
+type SearchQuery struct {
+	Keyword  string
+	User     int
+	Date     string
+	Flag     int
+	Tag      string
+	Notebook int
+	Group    int // group_id the notes are shared with
+}
+
+/*
+- Reads search filters from a query string (e.g. /dashboard?q=todo&user=2&flag=1&date=2024-01-01&tag=infra&notebook=3&group=1)
+Args:
+
+	values: query string values
+
+return: the search filters, missing or invalid filters match everything
+*/
+func parseSearchQuery(values url.Values) SearchQuery {
+	search := SearchQuery{
+		Keyword:  values.Get("q"),
+		User:     -1,
+		Date:     values.Get("date"),
+		Flag:     -1,
+		Tag:      normaliseTag(values.Get("tag")),
+		Notebook: -1,
+		Group:    -1,
+	}
+
+	if n, err := strconv.Atoi(values.Get("user")); err == nil {
+		search.User = n
+	}
+	if n, err := strconv.Atoi(values.Get("flag")); err == nil {
+		search.Flag = n
+	}
+	if n, err := strconv.Atoi(values.Get("notebook")); err == nil {
+		search.Notebook = n
+	}
+	if n, err := strconv.Atoi(values.Get("group")); err == nil {
+		search.Group = n
+	}
+	if _, err := time.Parse("2006-01-02", search.Date); err != nil {
+		search.Date = ""
+	}
+
+	return search
+}
+
+/*
+- Encodes the search filters as a query string, only filters that are set are included
+return: query string beginning with '?' or an empty string
+*/
+func (s SearchQuery) Encode() string {
+	values := url.Values{}
+	if s.Keyword != "" {
+		values.Set("q", s.Keyword)
+	}
+	if s.User != -1 {
+		values.Set("user", strconv.Itoa(s.User))
+	}
+	if s.Date != "" {
+		values.Set("date", s.Date)
+	}
+	if s.Flag != -1 {
+		values.Set("flag", strconv.Itoa(s.Flag))
+	}
+	if s.Tag != "" {
+		values.Set("tag", s.Tag)
+	}
+	if s.Notebook != -1 {
+		values.Set("notebook", strconv.Itoa(s.Notebook))
+	}
+	if s.Group != -1 {
+		values.Set("group", strconv.Itoa(s.Group))
+	}
+
+	if len(values) == 0 {
+		return ""
+	}
+	return "?" + values.Encode()
 }
 
 /*
@@ -41,128 +148,226 @@ func executeTemplate(w http.ResponseWriter, name, path string, funcMap template.
 */
 func createUserSession(w http.ResponseWriter, user User) {
 	s := session.NewSessionOptions(&session.SessOptions{
-		CAttrs: map[string]interface{}{"username": user.Username, "userid": user.Id},
-		Attrs:  map[string]interface{}{"count": 1},
+		CAttrs:  map[string]interface{}{"username": user.Username, "userid": user.Id},
+		Attrs:   map[string]interface{}{"count": 1},
+		Timeout: SessionIdleTimeout,
 	})
 	session.Add(s, w)
 }
 
+// Users a note's notebook, or any notebook above it, is shared with
+const noteNotebookShareColumn = "ARRAY(WITH RECURSIVE chain AS (" +
+	"SELECT notebook_id, notebook_parent, notebook_share FROM notebooks WHERE notebook_id=notes.note_notebook " +
+	"UNION SELECT nb.notebook_id, nb.notebook_parent, nb.notebook_share FROM notebooks nb JOIN chain c ON nb.notebook_id=c.notebook_parent" +
+	") SELECT DISTINCT unnest(notebook_share) FROM chain)"
+
+// JSON object of the highest role each member of the note's groups gets through them
+const noteGroupRolesColumn = "COALESCE((SELECT json_object_agg(user_id, share_role) FROM (" +
+	"SELECT gm.user_id, MAX(gs.share_role) AS share_role FROM note_group_shares gs JOIN group_members gm ON gm.group_id=gs.group_id " +
+	"WHERE gs.note_id=notes.note_id GROUP BY gm.user_id) members), '{}')"
+
+// Columns selected for a Note, in the order scanNote reads them
+const noteColumns = "note_id, note_owner, note_share, note_visibility, note_name, note_date, note_completion_date, note_flag, note_content, note_deleted, " +
+	"ARRAY(SELECT t.tag_name FROM note_tags nt JOIN tags t ON t.tag_id=nt.tag_id WHERE nt.note_id=notes.note_id ORDER BY t.tag_name), " +
+	"COALESCE(note_notebook, 0), " +
+	noteNotebookShareColumn + ", note_due_date, note_priority, note_assignees, " +
+	"COALESCE((SELECT state_terminal FROM workflow_states WHERE state_id=notes.note_flag), FALSE), " +
+	"COALESCE((SELECT json_object_agg(user_id, share_role) FROM note_shares WHERE note_id=notes.note_id), '{}'), " +
+	"COALESCE((SELECT json_object_agg(group_id, share_role) FROM note_group_shares WHERE note_id=notes.note_id), '{}'), " +
+	noteGroupRolesColumn
+
+// Implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
 /*
-- Fetches every note from the database
-return: List of notes or, an error
+- Reads a note from a row selected with noteColumns
+Args:
+
+	row: row to read
+	extra: destinations for any columns selected after noteColumns
+
+return: the note or an error
 */
-func (a *App) fetchNotes() ([]Note, error) {
-	noteCount := 0
+func scanNote(row rowScanner, extra ...any) (Note, error) {
+	var note Note
+	dest := []any{&note.Id, &note.Owner, &note.Share, &note.Visibility, &note.Name, &note.Date, &note.CompletionDate, &note.Flag, &note.Content, &note.Deleted, &note.Tags, &note.Notebook, &note.NotebookShare, &note.DueDate, &note.Priority, &note.Assignees, &note.Terminal, &note.ShareRoles, &note.GroupShares, &note.GroupRoles}
+	err := row.Scan(append(dest, extra...)...)
+	return note, err
+}
 
-	rows, err := a.db.Query("SELECT COUNT(note_id) FROM notes")
-	if err != nil {
-		return make([]Note, 0), err
-	}
-	defer rows.Close()
+/*
+- Fetches a single note from the database, notes in the trash are not returned
+Args:
 
-	for rows.Next() {
-		if e := rows.Scan(&noteCount); e != nil {
-			return make([]Note, 0), e
-		}
-	}
+	id: note_id of the note
+
+return: the note or an error (sql.ErrNoRows if it doesn't exist)
+*/
+func (a *App) fetchNote(id int32) (Note, error) {
+	return scanNote(a.db.QueryRow("SELECT "+noteColumns+" FROM notes WHERE note_id=$1 AND note_deleted IS NULL", id))
+}
 
-	notes := make([]Note, 0, noteCount)
+/*
+- Inserts a new note into the database and records it as the note's first revision
+Args:
 
-	rows, err = a.db.Query(
-		"SELECT note_owner, note_share, note_name, note_date, note_completion_date, note_flag, note_content FROM notes ORDER BY note_id DESC")
+	note: note to insert (note.Id is ignored)
+
+return: the new note_id or an error
+*/
+func (a *App) insertNote(note Note) (int32, error) {
+	tx, err := a.db.Begin()
 	if err != nil {
-		return make([]Note, 0), err
+		return 0, err
 	}
+	defer tx.Rollback()
 
-	for rows.Next() {
-		note := Note{}
+	err = tx.QueryRow("INSERT INTO notes(note_owner, note_share, note_visibility, note_name, note_date, note_completion_date, note_flag, note_content, note_notebook, note_due_date, note_priority) VALUES($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, 0), $10, $11) RETURNING note_id",
+		note.Owner, note.Share, note.Visibility, note.Name, note.Date, note.CompletionDate, note.Flag, note.Content, note.Notebook, note.DueDate, note.Priority).Scan(&note.Id)
+	if err != nil {
+		return 0, err
+	}
 
-		if e := rows.Scan(&note.Owner, &note.Share, &note.Name, &note.Date, &note.CompletionDate, &note.Flag, &note.Content); e != nil {
-			return notes, e
-		}
+	if err = setNoteTags(tx, note.Id, note.Tags); err != nil {
+		return 0, err
+	}
 
-		notes = append(notes, note)
+	if err = setNoteShares(tx, note); err != nil {
+		return 0, err
 	}
 
-	return notes, nil
+	if err = insertNoteRevision(tx, note, note.Owner); err != nil {
+		return 0, err
+	}
+
+	return note.Id, tx.Commit()
 }
 
 /*
-- Filters a list of notes so that it only contains what the user has access to.
+  - Writes the share and share roles, name, completion date, flag, content, tags, notebook, due date and priority of a note to the database
+    and records the change as a new revision
+
 Args:
 
-	user: user that will be used to filter the notes
-	notes: list of notes to be filtered
+	note: note to update, matched by note.Id
+	author: user making the change
 
-return: list of filtered notes
+return: nil or an error
 */
-func getAccessibleNotes(user User, notes []Note) []Note {
-	filteredNotes := make([]Note, 0, len(notes))
-	for _, note := range notes {
-		if len(note.Share) == 0 {
-			filteredNotes = append(filteredNotes, note)
-		}
+func (a *App) updateNote(note Note, author User) error {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-		for _, share_id := range note.Share {
-			if share_id == user.Id || note.Owner == user.Id {
-				filteredNotes = append(filteredNotes, note)
-				break
-			}
-		}
+	// Notes from before revisions existed get their current state saved first so it isn't lost
+	_, err = tx.Exec("INSERT INTO note_revisions(note_id, revision_author, revision_date, note_share, note_visibility, note_name, note_completion_date, note_flag, note_content) "+
+		"SELECT note_id, note_owner, note_date, note_share, note_visibility, note_name, note_completion_date, note_flag, note_content FROM notes "+
+		"WHERE note_id=$1 AND NOT EXISTS (SELECT 1 FROM note_revisions WHERE note_id=$1)", note.Id)
+	if err != nil {
+		return err
 	}
-	return filteredNotes
+
+	_, err = tx.Exec("UPDATE notes SET note_share=$1, note_visibility=$2, note_name=$3, note_completion_date=$4, note_flag=$5, note_content=$6, note_notebook=NULLIF($7, 0), note_due_date=$8, note_priority=$9 WHERE note_id=$10",
+		note.Share, note.Visibility, note.Name, note.CompletionDate, note.Flag, note.Content, note.Notebook, note.DueDate, note.Priority, note.Id)
+	if err != nil {
+		return err
+	}
+
+	if err = setNoteTags(tx, note.Id, note.Tags); err != nil {
+		return err
+	}
+
+	if err = setNoteShares(tx, note); err != nil {
+		return err
+	}
+
+	if err = insertNoteRevision(tx, note, author.Id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 /*
-- Filters a list of notes by keyword, user, date and flag
+- Replaces the content of a note only if nobody has changed it since it was read, every other column is left as it is
+and the change is recorded as a new revision
 Args:
 
-	notes: notes to be filtered
-	keyword: if note.Name or note.Content contains keyword then add to filteredNotes
-	user: if note.Owner == user then add to filteredNotes
-	date: if note.Date or note.CompletionDate == date then add to filteredNotes
-	flag: if note.Flag == flag then add to filteredNotes
+	note: note as it was read
+	author: user making the change
+	content: new content
 
-return: list of filtered notes
+return: false if the note's content changed after it was read, or an error
 */
-func searchNotes(notes []Note, keyword string, user int, date string, flag int) []Note {
-	filteredNotes := make([]Note, 0, len(notes))
+func (a *App) updateNoteContent(note Note, author User, content string) (bool, error) {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
 
-	for _, note := range notes {
-		hasKeyword, hasUser, hasDate, hasFlag := false, false, false, false
+	// Notes from before revisions existed get their current state saved first so it isn't lost
+	_, err = tx.Exec("INSERT INTO note_revisions(note_id, revision_author, revision_date, note_share, note_visibility, note_name, note_completion_date, note_flag, note_content) "+
+		"SELECT note_id, note_owner, note_date, note_share, note_visibility, note_name, note_completion_date, note_flag, note_content FROM notes "+
+		"WHERE note_id=$1 AND NOT EXISTS (SELECT 1 FROM note_revisions WHERE note_id=$1)", note.Id)
+	if err != nil {
+		return false, err
+	}
 
-		// Keyword search
-		if keyword == "" ||
-			strings.Contains(strings.ToLower(note.Name), strings.ToLower(keyword)) ||
-			strings.Contains(strings.ToLower(note.Content), strings.ToLower(keyword)) {
-			hasKeyword = true
-		}
+	res, err := tx.Exec("UPDATE notes SET note_content=$1 WHERE note_id=$2 AND note_content=$3", content, note.Id, note.Content)
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
 
-		// User search
-		if user == -1 || note.Owner == int32(user) {
-			hasUser = true
-		}
+	// The revision is taken from the row so edits to other fields made meanwhile are kept
+	_, err = tx.Exec("INSERT INTO note_revisions(note_id, revision_author, revision_date, note_share, note_visibility, note_name, note_completion_date, note_flag, note_content) "+
+		"SELECT note_id, $2, NOW(), note_share, note_visibility, note_name, note_completion_date, note_flag, note_content FROM notes WHERE note_id=$1",
+		note.Id, author.Id)
+	if err != nil {
+		return false, err
+	}
 
-		// Date search
-		if date == "" || note.Date.Format("2006-01-02") == date || (note.Flag == NoteFlagCompleted && note.CompletionDate.Format("2006-01-02") == date) {
-			hasDate = true
-		}
+	return true, tx.Commit()
+}
 
-		// Flag search
-		if flag == -1 || note.Flag == flag {
-			hasFlag = true
-		}
+/*
+- Moves a note to its owner's trash, it is purged after the trash retention period
+Args:
 
-		if hasKeyword && hasUser && hasDate && hasFlag {
-			filteredNotes = append(filteredNotes, note)
-		}
-	}
+	note: note to delete
 
-	return filteredNotes
+return: nil or an error
+*/
+func (a *App) deleteNote(note Note) error {
+	_, err := a.db.Exec("UPDATE notes SET note_deleted=NOW() WHERE note_id=$1 AND note_owner=$2", note.Id, note.Owner)
+	return err
 }
 
 /*
-- Fetches the current user using the current session
+- Gets the id from the request path (e.g. /notes/{id}/edit)
+Args:
+
+	r: http request
+
+return: the id or an error
+*/
+func getIdFromPath(r *http.Request) (int32, error) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	return int32(id), nil
+}
+
+/*
+- Fetches the current user using the api token or the current session
 Args:
 
 	r: http request
@@ -170,6 +375,10 @@ Args:
 return: the current user or an error
 */
 func (a *App) fetchCurrentUser(r *http.Request) (User, error) {
+	if user, ok := tokenUserFromRequest(r); ok {
+		return user, nil
+	}
+
 	sess := session.Get(r)
 	name := "[guest]"
 
@@ -186,6 +395,28 @@ func (a *App) fetchCurrentUser(r *http.Request) (User, error) {
 	return user, nil
 }
 
+/*
+- Fetches the name of a user
+Args:
+
+	id: user_id of the user
+
+return: the username (empty for the placeholder user) or an error
+*/
+func (a *App) fetchUserName(id int32) (string, error) {
+	name := ""
+	err := a.db.QueryRow("SELECT username FROM users WHERE user_id=$1", id).Scan(&name)
+	if err != nil {
+		return "", err
+	}
+
+	if name == "__placeholder__user__" {
+		return "", nil
+	}
+
+	return name, nil
+}
+
 /*
 - Fetches the settings for a user
 Args:
@@ -196,7 +427,7 @@ return: user settings or an error
 */
 func (a *App) fetchUserSettings(user User) (UserSettings, error) {
 	var settings UserSettings
-	err := a.db.QueryRow("SELECT setting_id, user_id, colleagues FROM user_settings WHERE user_id=$1", user.Id).Scan(&settings.Id, &settings.UserId, &settings.Colleagues)
+	err := a.db.QueryRow("SELECT setting_id, user_id, colleagues, share_colleagues_only FROM user_settings WHERE user_id=$1", user.Id).Scan(&settings.Id, &settings.UserId, &settings.Colleagues, &settings.ColleaguesOnly)
 	if err != nil {
 		return UserSettings{}, err
 	}
@@ -252,10 +483,10 @@ Args:
 	w: http response writer
 	r: http request
 
-return: list of user ids
+return: list of user ids, empty if none were picked (see NoteVisibility* for who can then see the note)
 */
-func getShareDetails(formIdPrefix string, otherUsers []User, w http.ResponseWriter, r *http.Request) []int {
-	var share []int
+func getShareDetails(formIdPrefix string, otherUsers []User, w http.ResponseWriter, r *http.Request) pq.Int32Array {
+	share := pq.Int32Array{}
 
 	for _, u := range otherUsers {
 		shareFormValueStr := r.FormValue(formIdPrefix + "-" + u.Username)
@@ -263,13 +494,109 @@ func getShareDetails(formIdPrefix string, otherUsers []User, w http.ResponseWrit
 			shareFormValue, err := strconv.Atoi(shareFormValueStr)
 			checkInternalServerError(err, w)
 
-			share = append(share, shareFormValue)
+			share = append(share, int32(shareFormValue))
 		}
 	}
 
-	if len(share) == 0 {
-		share = append(share, -1)
+	return share
+}
+
+/*
+- Gets the role picked for each user in the share fieldset
+Args:
+
+	formIdPrefix: input name prefix (e.g. 'create')
+	share: users the note is shared with (see getShareDetails)
+	otherUsers: list of users excluding the current one
+	r: http request
+
+return: role of each shared user, users without a valid role are viewers
+*/
+func getShareRoles(formIdPrefix string, share pq.Int32Array, otherUsers []User, r *http.Request) ShareRoles {
+	roles := ShareRoles{}
+	for _, u := range otherUsers {
+		if !slices.Contains(share, u.Id) {
+			continue
+		}
+
+		role, err := strconv.Atoi(r.FormValue(formIdPrefix + "-role-" + u.Username))
+		if err != nil || !isValidShareRole(role) {
+			role = NoteAccessViewer
+		}
+		roles[u.Id] = role
 	}
+	return roles
+}
 
-	return share
+/*
+- Gets the visibility picked in a note form
+Args:
+
+	formIdPrefix: input name prefix (e.g. 'create')
+	r: http request
+
+return: one of the NoteVisibility* constants and false if the form sent something else
+*/
+func getVisibility(formIdPrefix string, r *http.Request) (int, bool) {
+	visibility, err := strconv.Atoi(r.FormValue(formIdPrefix + "-note-visibility"))
+	if err != nil || !isValidNoteVisibility(visibility) {
+		return 0, false
+	}
+	return visibility, true
+}
+
+/*
+- Checks that a note priority is one of the NotePriority* constants
+*/
+func isValidNotePriority(priority int) bool {
+	return priority >= 0 && priority < NotePriorityMax
+}
+
+/*
+- Gets the display name of a note priority
+*/
+func notePriorityToString(priority int) string {
+	return []string{
+		"None",
+		"Low",
+		"Medium",
+		"High",
+	}[priority]
+}
+
+/*
+- Reads a due date from a form or api request
+Args:
+
+	s: date as YYYY-MM-DD, empty for no due date
+
+return: the due date (not valid if s is empty) and false if s isn't a date
+*/
+func parseDueDate(s string) (sql.NullTime, bool) {
+	if s == "" {
+		return sql.NullTime{}, true
+	}
+	date, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return sql.NullTime{}, false
+	}
+	return sql.NullTime{Time: date, Valid: true}, true
+}
+
+/*
+- Checks if a note is past its due date and not yet in a terminal state
+Args:
+
+	note: note to check
+	now: current time
+
+return: true if the note is overdue
+*/
+func isOverdue(note Note, now time.Time) bool {
+	if !note.DueDate.Valid || note.Terminal {
+		return false
+	}
+	due := note.DueDate.Time
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	return time.Date(due.Year(), due.Month(), due.Day(), 0, 0, 0, 0, time.UTC).Before(today)
 }
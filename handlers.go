@@ -6,17 +6,11 @@ import (
 	"errors"
 	"html/template"
 	"net/http"
+	"slices"
 	"strconv"
 	"time"
 )
 
-var (
-	searchByKeyword = ""
-	searchByUser    = -1
-	searchByDate    = ""
-	searchByFlag    = -1
-)
-
 func (a *App) indexHandler(w http.ResponseWriter, r *http.Request) {
 	if !isAuthenticated(w, r) {
 		return
@@ -35,23 +29,94 @@ func (a *App) dashboardHandler(w http.ResponseWriter, r *http.Request) {
 	settings, err := a.fetchUserSettings(user)
 	checkInternalServerError(err, w)
 
-	notes, err := a.fetchNotes()
+	tagCounts, err := a.fetchTagCounts(user)
+	checkInternalServerError(err, w)
 
+	wf, err := a.fetchWorkflow()
 	checkInternalServerError(err, w)
 
-	notes = getAccessibleNotes(user, notes)
-	notes = searchNotes(notes, searchByKeyword, searchByUser, searchByDate, searchByFlag)
+	search := parseSearchQuery(r.URL.Query())
+	page := parsePageQuery(r.URL.Query())
+	result, err := a.searchNotes(user, search, page, wf)
+
+	// A query that can't be parsed is shown to the user rather than treated as a server error
+	searchError := ""
+	total := 0
+	var searchErr *SearchError
+	if errors.As(err, &searchErr) {
+		searchError = searchErr.Error()
+		err = nil
+	} else if err == nil {
+		total, err = a.countNotes(user, search, wf)
+	}
+	checkInternalServerError(err, w)
+
+	pageUrl := func(cursor string, next bool) string {
+		if cursor == "" {
+			return ""
+		}
+		p := PageQuery{Sort: page.Sort, Order: page.Order, Limit: page.Limit}
+		if next {
+			p.After = cursor
+		} else {
+			p.Before = cursor
+		}
+		return "/dashboard" + p.Encode(search)
+	}
 
 	otherUsers, err := a.fetchUsersExclude(user)
 	clearUserPasswordHash(otherUsers)
 
 	checkInternalServerError(err, w)
 
+	tokens, err := a.fetchApiTokens(user)
+	checkInternalServerError(err, w)
+
+	trash, err := a.fetchTrash(user)
+	checkInternalServerError(err, w)
+
+	notebooks, err := a.fetchNotebooks()
+	checkInternalServerError(err, w)
+
+	groups, err := a.fetchGroups()
+	checkInternalServerError(err, w)
+
+	colleagueRequests, err := a.fetchColleagueRequests(user)
+	checkInternalServerError(err, w)
+
+	savedSearches, err := a.fetchSavedSearches(user)
+	checkInternalServerError(err, w)
+
+	savedSearchViews, err := a.countSavedSearches(user, savedSearches, wf)
+	checkInternalServerError(err, w)
+
+	dashboardViewCounts, err := a.countSavedSearches(user, dashboardViews(), wf)
+	checkInternalServerError(err, w)
+
 	tmplData := DashboardData{
 		CurrentUser:         user,
 		CurrentUserSettings: settings,
 		Users:               otherUsers,
-		Notes:               notes,
+		Notes:               result.Notes,
+		Highlights:          result.Highlights,
+		Search:              search,
+		Page:                page,
+		TotalNotes:          total,
+		NextPageUrl:         pageUrl(result.Next, true),
+		PrevPageUrl:         pageUrl(result.Prev, false),
+		SearchError:         searchError,
+		SavedSearches:       savedSearchViews,
+		DashboardViews:      dashboardViewCounts,
+		FlashMsg:            popFlash(w, r, FlashDashboard),
+		Tokens:              tokens,
+		Trash:               trash,
+		TagCounts:           tagCounts,
+		NotebookTree:        buildNotebookTree(user, notebooks),
+		OwnedNotebooks:      ownedNotebooks(user, notebooks),
+		Workflow:            wf,
+		IsAdmin:             a.isAdmin(user),
+		Groups:              userGroups(user, groups),
+		ColleagueRequests:   colleagueRequests,
 	}
 
 	executeTemplate(w, "dashboard.html", "web/dashboard.html",
@@ -60,16 +125,25 @@ func (a *App) dashboardHandler(w http.ResponseWriter, r *http.Request) {
 				return n + 1
 			},
 			"getUserName": func(id int32) string {
-				name := ""
-				err := a.db.QueryRow("SELECT username FROM users WHERE user_id=$1", id).Scan(&name)
+				name, err := a.fetchUserName(id)
 				checkInternalServerError(err, w)
-
-				if name == "__placeholder__user__" {
-					return ""
-				}
-
 				return name
 			},
+			"invitationCount": func() int {
+				count := 0
+				for _, req := range colleagueRequests {
+					if req.Recipient == user.Id {
+						count++
+					}
+				}
+				return count
+			},
+			"canInvite": func(id int32) bool {
+				pending := slices.ContainsFunc(colleagueRequests, func(req ColleagueRequest) bool {
+					return req.Requester == id || req.Recipient == id
+				})
+				return !pending && !slices.Contains(settings.Colleagues, id)
+			},
 			"isColleague": func(settings UserSettings, id int32) bool {
 				for _, colleague := range settings.Colleagues {
 					if colleague == id {
@@ -81,23 +155,44 @@ func (a *App) dashboardHandler(w http.ResponseWriter, r *http.Request) {
 			"shortDate": func(date time.Time) string {
 				return date.Format("02/01/2006")
 			},
+			"purgeDate": func(note Note) string {
+				return note.Deleted.Time.Add(a.trashRetention).Format("02/01/2006")
+			},
+			"tokenLastUsed": func(t ApiToken) string {
+				if t.LastUsed.Valid {
+					return t.LastUsed.Time.Format("02/01/2006 15:04")
+				}
+				return "Never"
+			},
+			"tokenScopeToString": func(scope int) string {
+				return []string{
+					"Read only",
+					"Read and write",
+				}[scope]
+			},
 			"completedDate": func(note Note) string {
-				if note.Flag == NoteFlagCompleted {
+				if note.Terminal {
 					return note.CompletionDate.Format("02/01/2006")
 				}
 				return "N/A"
 			},
-			"isNoteOwned": func(note Note) bool {
-				return note.Owner == user.Id
+			"canEditNote": func(note Note) bool {
+				return canEditNote(user, note)
 			},
-			"noteFlagToString": func(noteFlag int) string {
-				return []string{
-					"Note",
-					"In Progress",
-					"Completed",
-					"Cancelled",
-					"Delegated",
-				}[noteFlag]
+			"canDeleteNote": func(note Note) bool {
+				return canDeleteNote(user, note)
+			},
+			"isOverdue": func(note Note) bool {
+				return isOverdue(note, time.Now())
+			},
+			"noteFlagToString":       wf.stateName,
+			"noteFlagColour":         wf.stateColour,
+			"notePriorityToString":   notePriorityToString,
+			"noteVisibilityToString": noteVisibilityToString,
+			"markdown":               renderMarkdown,
+			"checklistProgress":      checklistProgress,
+			"notebookSharedWith": func(notebook Notebook, id int32) bool {
+				return slices.Contains(notebook.Share, id)
 			},
 			"json": func(s interface{}) string {
 				jsonBytes, err := json.Marshal(s)
@@ -115,12 +210,23 @@ func (a *App) searchHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	searchByKeyword = r.FormValue("search-by-keyword")
-	searchByUser, _ = strconv.Atoi(r.FormValue("search-by-user"))
-	searchByDate = r.FormValue("search-by-date")
-	searchByFlag, _ = strconv.Atoi(r.FormValue("search-by-flags"))
+	// Kept for old bookmarks and forms, the dashboard reads its filters from the query string
+	search := SearchQuery{
+		Keyword:  r.FormValue("search-by-keyword"),
+		User:     -1,
+		Date:     r.FormValue("search-by-date"),
+		Flag:     -1,
+		Notebook: -1,
+		Group:    -1,
+	}
+	if n, err := strconv.Atoi(r.FormValue("search-by-user")); err == nil {
+		search.User = n
+	}
+	if n, err := strconv.Atoi(r.FormValue("search-by-flags")); err == nil {
+		search.Flag = n
+	}
 
-	http.Redirect(w, r, "/dashboard", http.StatusMovedPermanently)
+	http.Redirect(w, r, "/dashboard"+search.Encode(), http.StatusSeeOther)
 }
 
 func (a *App) createNoteHandler(w http.ResponseWriter, r *http.Request) {
@@ -133,35 +239,95 @@ func (a *App) createNoteHandler(w http.ResponseWriter, r *http.Request) {
 
 	noteNameRaw := r.FormValue("create-note-name")
 	noteContent := r.FormValue("create-note-content")
+	noteTags := parseTags(r.FormValue("create-note-tags"))
 	noteFlag, err := strconv.Atoi(r.FormValue("create-note-flags"))
-
-	if err != nil || noteFlag >= NoteFlagMax {
+	if err != nil {
 		checkInternalServerError(errors.New("invalid note flag passed from create form"), w)
 		return
 	}
 
-	noteName := noteNameRaw[:minInt(len(noteNameRaw), NoteNameMaxLength)]
+	noteDueDate, ok := parseDueDate(r.FormValue("create-note-due"))
+	if !ok {
+		setFlash(w, FlashDashboard, "Due dates are written as YYYY-MM-DD")
+		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		return
+	}
+
+	notePriority, err := strconv.Atoi(r.FormValue("create-note-priority"))
+	if err != nil || !isValidNotePriority(notePriority) {
+		checkInternalServerError(errors.New("invalid note priority passed from create form"), w)
+		return
+	}
+
+	noteVisibility, ok := getVisibility("create", r)
+	if !ok {
+		http.Error(w, "invalid note visibility", http.StatusBadRequest)
+		return
+	}
+
+	noteName := truncateRunes(noteNameRaw, NoteNameMaxLength)
 
 	otherUsers, err := a.fetchUsersExclude(user)
 	checkInternalServerError(err, w)
 
 	share := getShareDetails("create", otherUsers, w, r)
 
-	var note Note
-	err = a.db.QueryRow("SELECT note_name FROM notes WHERE note_name=$1", noteName).Scan(&note.Name)
+	targets, err := a.fetchShareTargets(user)
+	checkInternalServerError(err, w)
 
-	switch {
-	case err == sql.ErrNoRows:
-		_, err = a.db.Exec("INSERT INTO notes(note_owner, note_share, note_name, note_date, note_completion_date, note_flag, note_content) VALUES($1, $2, $3, $4, $5, $6, $7)",
-			user.Id, share, noteName, time.Now(), time.Now(), noteFlag, noteContent)
-		checkInternalServerError(err, w)
-		http.Redirect(w, r, "/dashboard", http.StatusMovedPermanently)
-	case err != nil:
-		http.Error(w, "loi: "+err.Error(), http.StatusBadRequest)
+	share, dropped := keepShareTargets(share, nil, targets)
+
+	groups, err := a.fetchGroups()
+	checkInternalServerError(err, w)
+
+	notebooks, err := a.fetchNotebooks()
+	checkInternalServerError(err, w)
+
+	notebook, _ := strconv.Atoi(r.FormValue("create-note-notebook"))
+	if !isValidNotebookParent(user, int32(notebook), 0, notebooks) {
+		setFlash(w, FlashDashboard, "Notes can only be put in notebooks you own")
+		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		return
+	}
+
+	note := Note{
+		Owner:       user.Id,
+		Share:       share,
+		Visibility:  noteVisibility,
+		ShareRoles:  getShareRoles("create", share, otherUsers, r),
+		GroupShares: getGroupShares("create", userGroups(user, groups), r),
+		Name:        noteName,
+		Date:        time.Now(),
+		Content:     noteContent,
+		Tags:        noteTags,
+		Notebook:    int32(notebook),
+		DueDate:     noteDueDate,
+		Priority:    notePriority,
+	}
+
+	if msg := checkVisibility(note, user, len(note.Share) > 0 || len(note.GroupShares) > 0); msg != "" {
+		setFlash(w, FlashDashboard, "Couldn't create the note: "+msg)
+		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		return
+	}
+	note = normaliseVisibility(note)
+
+	wf, err := a.fetchWorkflow()
+	checkInternalServerError(err, w)
+
+	if msg := wf.startNote(&note, noteFlag); msg != "" {
+		setFlash(w, FlashDashboard, "Couldn't create the note: "+msg)
+		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
 		return
-	default:
-		http.Redirect(w, r, "/dashboard", http.StatusMovedPermanently)
 	}
+
+	_, err = a.insertNote(note)
+	checkInternalServerError(err, w)
+
+	if dropped {
+		setFlash(w, FlashDashboard, "'"+note.Name+"' wasn't shared with some people, they only accept notes from their colleagues")
+	}
+	http.Redirect(w, r, "/dashboard", http.StatusMovedPermanently)
 }
 
 func (a *App) editNoteHandler(w http.ResponseWriter, r *http.Request) {
@@ -172,36 +338,122 @@ func (a *App) editNoteHandler(w http.ResponseWriter, r *http.Request) {
 	user, err := a.fetchCurrentUser(r)
 	checkInternalServerError(err, w)
 
-	noteToEdit := r.FormValue("edit-select-note")
+	noteToEdit, err := getIdFromPath(r)
+	if err != nil {
+		http.Error(w, "invalid note id", http.StatusBadRequest)
+		return
+	}
+
 	editedNameRaw := r.FormValue("edit-note-name")
 	editedContent := r.FormValue("edit-note-content")
+	editedTags := parseTags(r.FormValue("edit-note-tags"))
 	editedFlag, err := strconv.Atoi(r.FormValue("edit-note-flags"))
-
-	if err != nil || editedFlag >= NoteFlagMax {
+	if err != nil {
 		checkInternalServerError(errors.New("invalid note flag passed from edit form"), w)
 		return
 	}
 
-	editedName := editedNameRaw[:minInt(len(editedNameRaw), NoteNameMaxLength)]
+	editedDueDate, ok := parseDueDate(r.FormValue("edit-note-due"))
+	if !ok {
+		setFlash(w, FlashDashboard, "Due dates are written as YYYY-MM-DD")
+		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		return
+	}
+
+	editedPriority, err := strconv.Atoi(r.FormValue("edit-note-priority"))
+	if err != nil || !isValidNotePriority(editedPriority) {
+		checkInternalServerError(errors.New("invalid note priority passed from edit form"), w)
+		return
+	}
+
+	editedName := truncateRunes(editedNameRaw, NoteNameMaxLength)
 
 	otherUsers, err := a.fetchUsersExclude(user)
 	checkInternalServerError(err, w)
 
 	editedShare := getShareDetails("edit", otherUsers, w, r)
 
-	var note Note
-	err = a.db.QueryRow("SELECT note_name FROM notes WHERE note_name=$1", noteToEdit).Scan(&note.Name)
+	groups, err := a.fetchGroups()
+	checkInternalServerError(err, w)
+	editedNotebook, _ := strconv.Atoi(r.FormValue("edit-note-notebook"))
+
+	notebooks, err := a.fetchNotebooks()
+	checkInternalServerError(err, w)
+
+	wf, err := a.fetchWorkflow()
+	checkInternalServerError(err, w)
+
+	note, err := a.fetchNote(noteToEdit)
 
 	switch {
 	case err == sql.ErrNoRows:
+		setFlash(w, FlashDashboard, "That note no longer exists")
 		http.Redirect(w, r, "/dashboard", http.StatusMovedPermanently)
 	case err != nil:
 		http.Error(w, "loi: "+err.Error(), http.StatusBadRequest)
 		return
+	case !canEditNote(user, note):
+		forbidden(w)
+		return
 	default:
-		_, err = a.db.Exec("UPDATE notes SET note_share=$1, note_name=$2, note_completion_date=$3, note_flag=$4, note_content=$5 WHERE note_name=$6",
-			editedShare, editedName, time.Now(), editedFlag, editedContent, noteToEdit)
+		// Only the owner can move the note between their notebooks
+		if canMoveNote(user, note) {
+			if !isValidNotebookParent(user, int32(editedNotebook), 0, notebooks) {
+				setFlash(w, FlashDashboard, "Notes can only be put in notebooks you own")
+				http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+				return
+			}
+			note.Notebook = int32(editedNotebook)
+		}
+
+		// Editors keep the existing share list, groups the user isn't in stay shared
+		dropped := false
+		if canShareNote(user, note) {
+			editedVisibility, ok := getVisibility("edit", r)
+			if !ok {
+				http.Error(w, "invalid note visibility", http.StatusBadRequest)
+				return
+			}
+
+			myGroups := userGroups(user, groups)
+			editedGroups := getGroupShares("edit", myGroups, r)
+
+			note.Visibility = editedVisibility
+			if msg := checkVisibility(note, user, len(editedShare) > 0 || len(editedGroups) > 0); msg != "" {
+				setFlash(w, FlashDashboard, "Couldn't edit '"+note.Name+"': "+msg)
+				http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+				return
+			}
+
+			targets, err := a.fetchShareTargets(user)
+			checkInternalServerError(err, w)
+
+			editedShare, dropped = keepShareTargets(editedShare, note.Share, targets)
+			note.Share = editedShare
+			note.ShareRoles = getShareRoles("edit", editedShare, otherUsers, r)
+			note.GroupShares = mergeGroupShares(note.GroupShares, editedGroups, myGroups)
+			note.GroupRoles = groupRoles(note.GroupShares, groups)
+			note = keepCoOwner(normaliseVisibility(note), user)
+		}
+
+		if msg := wf.moveNote(&note, editedFlag); msg != "" {
+			setFlash(w, FlashDashboard, "Couldn't edit '"+note.Name+"': "+msg)
+			http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+			return
+		}
+
+		note.Name = editedName
+		note.Content = editedContent
+		note.Tags = editedTags
+		note.DueDate = editedDueDate
+		note.Priority = editedPriority
+
+		err = a.updateNote(note, user)
 		checkInternalServerError(err, w)
+
+		if dropped {
+			setFlash(w, FlashDashboard, "'"+note.Name+"' wasn't shared with some people, they only accept notes from their colleagues")
+		}
 		http.Redirect(w, r, "/dashboard", http.StatusMovedPermanently)
 	}
 }
@@ -214,19 +466,26 @@ func (a *App) deleteNoteHandler(w http.ResponseWriter, r *http.Request) {
 	user, err := a.fetchCurrentUser(r)
 	checkInternalServerError(err, w)
 
-	noteToDelete := r.FormValue("delete-select-note")
+	noteToDelete, err := getIdFromPath(r)
+	if err != nil {
+		http.Error(w, "invalid note id", http.StatusBadRequest)
+		return
+	}
 
-	var note Note
-	err = a.db.QueryRow("SELECT note_name FROM notes WHERE note_name=$1", noteToDelete).Scan(&note.Name)
+	note, err := a.fetchNote(noteToDelete)
 
 	switch {
 	case err == sql.ErrNoRows:
+		setFlash(w, FlashDashboard, "That note no longer exists")
 		http.Redirect(w, r, "/dashboard", http.StatusMovedPermanently)
 	case err != nil:
 		http.Error(w, "loi: "+err.Error(), http.StatusBadRequest)
 		return
+	case !canDeleteNote(user, note):
+		forbidden(w)
+		return
 	default:
-		_, err = a.db.Exec("DELETE FROM notes WHERE note_name=$1 AND note_owner=$2", noteToDelete, user.Id)
+		err = a.deleteNote(note)
 		checkInternalServerError(err, w)
 		http.Redirect(w, r, "/dashboard", http.StatusMovedPermanently)
 	}
@@ -240,18 +499,9 @@ func (a *App) editSettingsHandler(w http.ResponseWriter, r *http.Request) {
 	user, err := a.fetchCurrentUser(r)
 	checkInternalServerError(err, w)
 
-	otherUsers, err := a.fetchUsersExclude(user)
-	checkInternalServerError(err, w)
-
-	colleagues := getShareDetails("settings", otherUsers, w, r)
-	if colleagues[0] == -1 {
-		return
-	}
-
-	settings, err := a.fetchUserSettings(user)
-	checkInternalServerError(err, w)
-
-	_, err = a.db.Exec("UPDATE user_settings SET colleagues=$1 WHERE setting_id=$2", colleagues, settings.Id)
+	// Colleagues are added with invitations (see colleagues.go)
+	_, err = a.db.Exec("UPDATE user_settings SET share_colleagues_only=$1 WHERE user_id=$2",
+		r.FormValue("settings-colleagues-only") != "", user.Id)
 	checkInternalServerError(err, w)
 
 	http.Redirect(w, r, "/dashboard", http.StatusMovedPermanently)
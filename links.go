@@ -0,0 +1,602 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type PublicNoteData struct {
+	Note         Note
+	NeedPassword bool // the link has a password that hasn't been given yet
+	ErrMsg       string
+}
+
+/* - Public link as it is sent by the api (never includes the hashes) - */
+type apiNoteLink struct {
+	Id         int32      `json:"id"`
+	Label      string     `json:"label"`
+	CreatedBy  int32      `json:"created_by"`
+	Created    time.Time  `json:"created"`
+	Expires    *time.Time `json:"expires"`
+	Password   bool       `json:"password"` // the link is password protected
+	Views      int        `json:"views"`
+	LastViewed *time.Time `json:"last_viewed"`
+	Url        string     `json:"url,omitempty"` // only returned when the link is created
+}
+
+/* - Body of a create link request - */
+type apiNoteLinkRequest struct {
+	Label    string     `json:"label"`
+	Expires  *time.Time `json:"expires"`
+	Password string     `json:"password"`
+}
+
+// Cookies set on a public link's page, they are only sent back to that link
+const (
+	linkUnlockCookie = "link-unlock" // the right password was given
+	linkViewedCookie = "link-viewed" // the view has been counted
+)
+
+/* - Password attempts on each public link, kept in memory so a restart clears them - */
+type linkAttempts struct {
+	mu       sync.Mutex
+	attempts map[int32][]time.Time // link_id to when each attempt in the last LinkPasswordWindow was made
+}
+
+func newLinkAttempts() *linkAttempts {
+	return &linkAttempts{attempts: map[int32][]time.Time{}}
+}
+
+/*
+- Records a password attempt on a link if it hasn't had too many, every password is checked with bcrypt so
+guessing is slow and can't tie up the server
+Args:
+
+	linkId: link_id of the link
+	now: time of the attempt
+
+return: false if the link has had LinkPasswordAttempts attempts in the last LinkPasswordWindow
+*/
+func (l *linkAttempts) attempt(linkId int32, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	recent := slices.DeleteFunc(l.attempts[linkId], func(t time.Time) bool { return now.Sub(t) >= LinkPasswordWindow })
+	if len(recent) >= LinkPasswordAttempts {
+		l.attempts[linkId] = recent
+		return false
+	}
+	l.attempts[linkId] = append(recent, now)
+	return true
+}
+
+/*
+- Chooses the address public links are given out on, the request's Host header is set by the client so it
+can't be trusted for this
+Args:
+
+	port: port the server is bound to, used when PUBLIC_URL isn't set
+
+return: PUBLIC_URL (e.g. https://notes.example.com) if it is an absolute http(s) url, otherwise http://localhost:<port>
+*/
+func findPublicUrl(port string) string {
+	publicUrl := os.Getenv("PUBLIC_URL")
+
+	u, err := url.Parse(publicUrl)
+	if err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+		return strings.TrimSuffix(publicUrl, "/")
+	}
+
+	if publicUrl != "" {
+		log.Printf("PUBLIC_URL %q isn't an absolute http(s) url, it is ignored\n", publicUrl)
+	}
+	return "http://localhost:" + port
+}
+
+/*
+- Gets the address of a public link
+Args:
+
+	token: the link's token
+
+return: absolute url of the link
+*/
+func (a *App) publicLinkUrl(token string) string {
+	return a.publicUrl + "/s/" + token
+}
+
+/*
+- Remembers that a browser gave the right password for a link, the cookie is signed and expires after LinkUnlockLifetime
+Args:
+
+	w: http response writer
+	r: request to the link's page, the cookie is limited to its path
+	link: link that was opened
+	now: when the password was given
+*/
+func setLinkUnlocked(w http.ResponseWriter, r *http.Request, link NoteLink, now time.Time) {
+	expires := strconv.FormatInt(now.Add(LinkUnlockLifetime).Unix(), 10)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     linkUnlockCookie,
+		Value:    expires + "." + signFlash(linkUnlockCookie+":"+link.Hash, expires),
+		Path:     r.URL.Path,
+		MaxAge:   int(LinkUnlockLifetime.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+/*
+- Checks if a browser has given the right password for a link recently (see setLinkUnlocked)
+return: false if there is no cookie, it was tampered with, it is for another link or it has expired
+*/
+func isLinkUnlocked(r *http.Request, link NoteLink, now time.Time) bool {
+	cookie, err := r.Cookie(linkUnlockCookie)
+	if err != nil {
+		return false
+	}
+
+	expires, sig, ok := strings.Cut(cookie.Value, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(signFlash(linkUnlockCookie+":"+link.Hash, expires))) {
+		return false
+	}
+
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	return err == nil && now.Unix() < unix
+}
+
+/*
+- Creates a public link to a note
+Args:
+
+	note: note the link shows
+	by: user creating the link
+	label: name to remember the link by
+	expires: when the link stops working, not set for a link that never expires
+	password: password needed to open the link, empty for none
+
+return: the saved link, its token (only ever available here), an error message for the user or an error
+*/
+func (a *App) createNoteLink(note Note, by User, label string, expires sql.NullTime, password string) (NoteLink, string, string, error) {
+	if expires.Valid && !expires.Time.After(time.Now()) {
+		return NoteLink{}, "", "the expiry has to be in the future", nil
+	}
+	if len(password) > LinkPasswordMaxLength {
+		return NoteLink{}, "", "passwords can be at most " + strconv.Itoa(LinkPasswordMaxLength) + " characters", nil
+	}
+
+	link := NoteLink{
+		NoteId:    note.Id,
+		CreatedBy: by.Id,
+		Label:     truncateRunes(label, LinkLabelMaxLength),
+		Created:   time.Now(),
+		Expires:   expires,
+	}
+
+	if password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return NoteLink{}, "", "", err
+		}
+		link.Password = string(hash)
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return NoteLink{}, "", "", err
+	}
+	token := hex.EncodeToString(raw)
+	link.Hash = hashApiToken(token)
+
+	err := a.db.QueryRow("INSERT INTO note_links(note_id, link_created_by, link_label, link_hash, link_password, link_created, link_expires) VALUES($1, $2, $3, $4, $5, $6, $7) RETURNING link_id",
+		link.NoteId, link.CreatedBy, link.Label, link.Hash, link.Password, link.Created, link.Expires).Scan(&link.Id)
+
+	return link, token, "", err
+}
+
+const noteLinkColumns = "link_id, note_id, link_created_by, link_label, link_hash, link_password, link_created, link_expires, link_views, link_last_viewed"
+
+func scanNoteLink(row rowScanner) (NoteLink, error) {
+	var l NoteLink
+	err := row.Scan(&l.Id, &l.NoteId, &l.CreatedBy, &l.Label, &l.Hash, &l.Password, &l.Created, &l.Expires, &l.Views, &l.LastViewed)
+	return l, err
+}
+
+/*
+- Fetches the links to a note that haven't expired, newest first
+Args:
+
+	noteId: note_id of the note
+
+return: list of links or an error
+*/
+func (a *App) fetchNoteLinks(noteId int32) ([]NoteLink, error) {
+	rows, err := a.db.Query("SELECT "+noteLinkColumns+" FROM note_links WHERE note_id=$1 AND (link_expires IS NULL OR link_expires>NOW()) ORDER BY link_id DESC",
+		noteId)
+	if err != nil {
+		return make([]NoteLink, 0), err
+	}
+	defer rows.Close()
+
+	links := []NoteLink{}
+	for rows.Next() {
+		l, e := scanNoteLink(rows)
+		if e != nil {
+			return make([]NoteLink, 0), e
+		}
+		links = append(links, l)
+	}
+
+	return links, nil
+}
+
+/*
+- Revokes (deletes) a link to a note
+Args:
+
+	noteId: note the link belongs to
+	linkId: link_id of the link
+
+return: false if the note has no such link, or an error
+*/
+func (a *App) revokeNoteLink(noteId, linkId int32) (bool, error) {
+	res, err := a.db.Exec("DELETE FROM note_links WHERE link_id=$1 AND note_id=$2", linkId, noteId)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+/*
+- Reads the expiry date picked in the create link form, the link works until the end of that day
+*/
+func parseLinkExpiry(s string) (sql.NullTime, bool) {
+	if s == "" {
+		return sql.NullTime{}, true
+	}
+	date, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		return sql.NullTime{}, false
+	}
+	return sql.NullTime{Time: date.AddDate(0, 0, 1), Valid: true}, true
+}
+
+/*
+- Serves a note to anyone with a public link, no account is needed. Unknown, expired and revoked links
+and notes in the trash are all not found so a link can't be told apart from one that never existed
+*/
+func (a *App) publicNoteHandler(w http.ResponseWriter, r *http.Request) {
+	// The token is in the url, don't pass it on to sites linked from the note
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("X-Robots-Tag", "noindex")
+
+	link, err := scanNoteLink(a.db.QueryRow("SELECT "+noteLinkColumns+" FROM note_links WHERE link_hash=$1 AND (link_expires IS NULL OR link_expires>NOW())",
+		hashApiToken(mux.Vars(r)["token"])))
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		checkInternalServerError(err, w)
+		return
+	}
+
+	note, err := a.fetchNote(link.NoteId)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		checkInternalServerError(err, w)
+		return
+	}
+
+	now := time.Now()
+	if link.Password != "" && !isLinkUnlocked(r, link, now) {
+		a.linkPasswordForm(w, r, link, now)
+		return
+	}
+
+	// Reloading the note in the same browser isn't another view
+	if _, err := r.Cookie(linkViewedCookie); err != nil {
+		_, err = a.db.Exec("UPDATE note_links SET link_views=link_views+1, link_last_viewed=NOW() WHERE link_id=$1", link.Id)
+		if err != nil {
+			checkInternalServerError(err, w)
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     linkViewedCookie,
+			Value:    "1",
+			Path:     r.URL.Path,
+			MaxAge:   int(LinkViewWindow.Seconds()),
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+
+	executeTemplate(w, "share.html", "web/share.html",
+		template.FuncMap{
+			"markdown": renderMarkdown,
+		},
+		PublicNoteData{Note: note})
+}
+
+/*
+- Asks for a link's password, or checks the one that was posted and sends the browser back to the note
+Args:
+
+	w: http response writer
+	r: http request
+	link: password protected link
+	now: time of the request
+*/
+func (a *App) linkPasswordForm(w http.ResponseWriter, r *http.Request, link NoteLink, now time.Time) {
+	tmplData := PublicNoteData{NeedPassword: true}
+
+	if r.Method == http.MethodPost {
+		switch {
+		case !a.linkAttempts.attempt(link.Id, now):
+			tmplData.ErrMsg = "Too many attempts, try again later"
+			w.WriteHeader(http.StatusTooManyRequests)
+		case bcrypt.CompareHashAndPassword([]byte(link.Password), []byte(r.FormValue("link-password"))) != nil:
+			tmplData.ErrMsg = "Incorrect password"
+		default:
+			// Redirecting means reloading the note doesn't post the password again
+			setLinkUnlocked(w, r, link, now)
+			http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+			return
+		}
+	}
+
+	executeTemplate(w, "share.html", "web/share.html",
+		template.FuncMap{
+			"markdown": renderMarkdown,
+		},
+		tmplData)
+}
+
+/*
+- Gets the note in the request path if the current user can manage its public links (the owner and co-owners)
+Args:
+
+	w: http response writer
+	r: http request
+	user: current user
+
+return: the note and true, or false if a response has already been sent
+*/
+func (a *App) linkNoteFromPath(w http.ResponseWriter, r *http.Request, user User) (Note, bool) {
+	noteId, err := getIdFromPath(r)
+	if err != nil {
+		http.Error(w, "invalid note id", http.StatusBadRequest)
+		return Note{}, false
+	}
+
+	note, err := a.fetchNote(noteId)
+	switch {
+	case err == sql.ErrNoRows:
+		setFlash(w, FlashDashboard, "That note no longer exists")
+		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		return Note{}, false
+	case err != nil:
+		checkInternalServerError(err, w)
+		return Note{}, false
+	case !canShareNote(user, note):
+		forbidden(w)
+		return Note{}, false
+	}
+
+	return note, true
+}
+
+func (a *App) createNoteLinkHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAuthenticated(w, r) {
+		return
+	}
+
+	user, err := a.fetchCurrentUser(r)
+	checkInternalServerError(err, w)
+
+	note, ok := a.linkNoteFromPath(w, r, user)
+	if !ok {
+		return
+	}
+
+	history := "/notes/" + strconv.Itoa(int(note.Id)) + "/history"
+
+	expires, ok := parseLinkExpiry(r.FormValue("link-expires"))
+	if !ok {
+		setFlash(w, FlashDashboard, "Expiry dates are written as YYYY-MM-DD")
+		http.Redirect(w, r, history, http.StatusSeeOther)
+		return
+	}
+
+	_, token, msg, err := a.createNoteLink(note, user, r.FormValue("link-label"), expires, r.FormValue("link-password"))
+	if err != nil {
+		checkInternalServerError(err, w)
+		return
+	}
+
+	if msg != "" {
+		setFlash(w, FlashDashboard, "Couldn't create the link: "+msg)
+		http.Redirect(w, r, history, http.StatusSeeOther)
+		return
+	}
+
+	showSecret(w, SecretData{
+		Title:   "New Public Link",
+		Message: "Copy the link now, it won't be shown again.",
+		Secret:  a.publicLinkUrl(token),
+		Back:    history,
+	})
+}
+
+func (a *App) revokeNoteLinkHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAuthenticated(w, r) {
+		return
+	}
+
+	user, err := a.fetchCurrentUser(r)
+	checkInternalServerError(err, w)
+
+	note, ok := a.linkNoteFromPath(w, r, user)
+	if !ok {
+		return
+	}
+
+	linkId, err := strconv.Atoi(mux.Vars(r)["link"])
+	if err != nil {
+		http.Error(w, "invalid link id", http.StatusBadRequest)
+		return
+	}
+
+	_, err = a.revokeNoteLink(note.Id, int32(linkId))
+	checkInternalServerError(err, w)
+
+	http.Redirect(w, r, "/notes/"+strconv.Itoa(int(note.Id))+"/history", http.StatusSeeOther)
+}
+
+func toApiNoteLink(l NoteLink) apiNoteLink {
+	link := apiNoteLink{
+		Id:        l.Id,
+		Label:     l.Label,
+		CreatedBy: l.CreatedBy,
+		Created:   l.Created,
+		Password:  l.Password != "",
+		Views:     l.Views,
+	}
+	if l.Expires.Valid {
+		link.Expires = &l.Expires.Time
+	}
+	if l.LastViewed.Valid {
+		link.LastViewed = &l.LastViewed.Time
+	}
+	return link
+}
+
+/*
+- Gets the note in the request path if the current user can manage its public links
+return: the note and true, or false if an error has already been sent
+*/
+func (a *App) apiLinkNoteFromPath(w http.ResponseWriter, r *http.Request, user User) (Note, bool) {
+	note, ok := a.apiNoteFromPath(w, r, user)
+	if !ok {
+		return Note{}, false
+	}
+
+	if !canShareNote(user, note) {
+		writeJSONError(w, http.StatusForbidden, "only the owner and co-owners can manage public links")
+		return Note{}, false
+	}
+	return note, true
+}
+
+// GET /api/v1/notes/{id}/links
+func (a *App) apiListNoteLinksHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.apiCurrentUser(w, r)
+	if !ok {
+		return
+	}
+
+	note, ok := a.apiLinkNoteFromPath(w, r, user)
+	if !ok {
+		return
+	}
+
+	links, err := a.fetchNoteLinks(note.Id)
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	apiLinks := make([]apiNoteLink, 0, len(links))
+	for _, l := range links {
+		apiLinks = append(apiLinks, toApiNoteLink(l))
+	}
+
+	writeJSON(w, http.StatusOK, apiLinks)
+}
+
+// POST /api/v1/notes/{id}/links, body {"label": "...", "expires": "2024-01-01T00:00:00Z", "password": "..."}
+func (a *App) apiCreateNoteLinkHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.apiCurrentUser(w, r)
+	if !ok {
+		return
+	}
+
+	note, ok := a.apiLinkNoteFromPath(w, r, user)
+	if !ok {
+		return
+	}
+
+	var req apiNoteLinkRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	expires := sql.NullTime{}
+	if req.Expires != nil {
+		expires = sql.NullTime{Time: *req.Expires, Valid: true}
+	}
+
+	link, token, msg, err := a.createNoteLink(note, user, req.Label, expires, req.Password)
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+	if msg != "" {
+		writeJSONError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	apiLink := toApiNoteLink(link)
+	apiLink.Url = a.publicLinkUrl(token)
+	writeJSON(w, http.StatusCreated, apiLink)
+}
+
+// DELETE /api/v1/notes/{id}/links/{link}
+func (a *App) apiRevokeNoteLinkHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.apiCurrentUser(w, r)
+	if !ok {
+		return
+	}
+
+	note, ok := a.apiLinkNoteFromPath(w, r, user)
+	if !ok {
+		return
+	}
+
+	linkId, err := strconv.Atoi(mux.Vars(r)["link"])
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid link id")
+		return
+	}
+
+	found, err := a.revokeNoteLink(note.Id, int32(linkId))
+	switch {
+	case err != nil:
+		writeJSONInternalError(w, err)
+	case !found:
+		writeJSONError(w, http.StatusNotFound, "link not found")
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
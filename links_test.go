@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLinkAttempts(t *testing.T) {
+	attempts := newLinkAttempts()
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < LinkPasswordAttempts; i++ {
+		if !attempts.attempt(1, start) {
+			t.Fatalf("attempt %d was refused", i+1)
+		}
+	}
+	if attempts.attempt(1, start.Add(time.Minute)) {
+		t.Error("an attempt over the limit was allowed")
+	}
+	if !attempts.attempt(2, start) {
+		t.Error("another link was limited by the first one's attempts")
+	}
+	if !attempts.attempt(1, start.Add(LinkPasswordWindow)) {
+		t.Error("attempts older than the window still counted")
+	}
+}
+
+func TestLinkUnlockCookie(t *testing.T) {
+	link := NoteLink{Id: 1, Hash: "abc"}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// Sends the cookies a response set back with a new request
+	unlocked := func(link NoteLink, at time.Time, tamper func(c *http.Cookie)) bool {
+		rec := httptest.NewRecorder()
+		setLinkUnlocked(rec, httptest.NewRequest(http.MethodPost, "/s/token", nil), NoteLink{Id: 1, Hash: "abc"}, now)
+
+		req := httptest.NewRequest(http.MethodGet, "/s/token", nil)
+		for _, c := range rec.Result().Cookies() {
+			if c.Path != "/s/token" {
+				t.Errorf("cookie path = %q, want /s/token", c.Path)
+			}
+			if tamper != nil {
+				tamper(c)
+			}
+			req.AddCookie(c)
+		}
+		return isLinkUnlocked(req, link, at)
+	}
+
+	if !unlocked(link, now, nil) {
+		t.Error("the link wasn't unlocked after the password was given")
+	}
+	if unlocked(link, now.Add(LinkUnlockLifetime), nil) {
+		t.Error("the link was still unlocked after the cookie expired")
+	}
+	if unlocked(NoteLink{Id: 2, Hash: "def"}, now, nil) {
+		t.Error("the cookie unlocked another link")
+	}
+	if unlocked(link, now, func(c *http.Cookie) { c.Value = "9999999999" + c.Value[10:] }) {
+		t.Error("a cookie with a changed expiry was accepted")
+	}
+	if isLinkUnlocked(httptest.NewRequest(http.MethodGet, "/s/token", nil), link, now) {
+		t.Error("the link was unlocked without a cookie")
+	}
+}
+
+func TestFindPublicUrl(t *testing.T) {
+	tests := []struct {
+		env  string
+		want string
+	}{
+		{"", "http://localhost:8080"},
+		{"https://notes.example.com", "https://notes.example.com"},
+		{"https://notes.example.com/", "https://notes.example.com"},
+		{"http://example.com/notes/", "http://example.com/notes"},
+		{"notes.example.com", "http://localhost:8080"},
+		{"ftp://example.com", "http://localhost:8080"},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("PUBLIC_URL", tt.env)
+		if got := findPublicUrl("8080"); got != tt.want {
+			t.Errorf("findPublicUrl with PUBLIC_URL=%q = %q, want %q", tt.env, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"net/http"
+	"regexp"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+// GitHub flavoured markdown: tables, task lists, strikethrough and links
+var markdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+// Everything markdown renders to is passed through this so shared notes can't inject scripts
+var markdownPolicy = newMarkdownPolicy()
+
+func newMarkdownPolicy() *bluemonday.Policy {
+	policy := bluemonday.UGCPolicy()
+
+	// Task list items are rendered as disabled checkboxes
+	policy.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	policy.AllowAttrs("checked", "disabled").OnElements("input")
+
+	// Fenced code blocks keep their language
+	policy.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w+-]+$`)).OnElements("code")
+
+	return policy
+}
+
+/*
+- Renders note content written in markdown to sanitised HTML
+Args:
+
+	content: markdown source
+
+return: HTML that is safe to put in a page
+*/
+func renderMarkdown(content string) template.HTML {
+	var buf bytes.Buffer
+	if err := markdown.Convert([]byte(content), &buf); err != nil {
+		// Fall back to the escaped source rather than showing nothing
+		log.Print(err)
+		return template.HTML(template.HTMLEscapeString(content))
+	}
+
+	return template.HTML(markdownPolicy.SanitizeBytes(buf.Bytes()))
+}
+
+// POST /notes/preview, renders the 'content' form value for the create and edit forms
+func (a *App) previewNoteHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAuthenticated(w, r) {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+	content := r.FormValue("content")
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(renderMarkdown(content)))
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/lib/pq"
+)
+
+/* - Notebook as it is sent by the api - */
+type apiNotebook struct {
+	Id     int32   `json:"id"`
+	Owner  int32   `json:"owner"`
+	Parent int32   `json:"parent"`
+	Name   string  `json:"name"`
+	Share  []int32 `json:"share"`
+}
+
+/* - A notebook and the notebooks inside it, used to draw the sidebar - */
+type NotebookNode struct {
+	Notebook Notebook
+	Children []NotebookNode
+}
+
+/*
+- Fetches every notebook from the database
+return: list of notebooks or an error
+*/
+func (a *App) fetchNotebooks() ([]Notebook, error) {
+	rows, err := a.db.Query("SELECT notebook_id, notebook_owner, COALESCE(notebook_parent, 0), notebook_name, notebook_share FROM notebooks ORDER BY notebook_name")
+	if err != nil {
+		return make([]Notebook, 0), err
+	}
+	defer rows.Close()
+
+	notebooks := []Notebook{}
+	for rows.Next() {
+		var nb Notebook
+		if e := rows.Scan(&nb.Id, &nb.Owner, &nb.Parent, &nb.Name, &nb.Share); e != nil {
+			return make([]Notebook, 0), e
+		}
+		notebooks = append(notebooks, nb)
+	}
+
+	return notebooks, nil
+}
+
+/*
+- Checks if a user can see a notebook: they own it, or it or one of its parents is shared with them
+Args:
+
+	user: user requesting access
+	notebook: notebook being accessed
+	byId: every notebook mapped from its id
+
+return: true if the user can see the notebook
+*/
+func canViewNotebook(user User, notebook Notebook, byId map[int32]Notebook) bool {
+	if notebook.Owner == user.Id {
+		return true
+	}
+
+	// Walk up the parents, 'seen' guards against a cycle in bad data
+	seen := map[int32]bool{}
+	for nb, ok := notebook, true; ok && !seen[nb.Id]; nb, ok = byId[nb.Parent] {
+		seen[nb.Id] = true
+		for _, shareId := range nb.Share {
+			if shareId == user.Id {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+/*
+- Builds the tree of notebooks a user can see
+Args:
+
+	user: user the tree is built for
+	notebooks: every notebook
+
+return: top level nodes, a notebook whose parent can't be seen is shown at the top level
+*/
+func buildNotebookTree(user User, notebooks []Notebook) []NotebookNode {
+	byId := make(map[int32]Notebook, len(notebooks))
+	for _, nb := range notebooks {
+		byId[nb.Id] = nb
+	}
+
+	visible := map[int32]bool{}
+	children := map[int32][]Notebook{}
+	for _, nb := range notebooks {
+		if canViewNotebook(user, nb, byId) {
+			visible[nb.Id] = true
+		}
+	}
+	for _, nb := range notebooks {
+		if !visible[nb.Id] {
+			continue
+		}
+		parent := nb.Parent
+		if !visible[parent] {
+			parent = 0
+		}
+		children[parent] = append(children[parent], nb)
+	}
+
+	var build func(parent int32, seen map[int32]bool) []NotebookNode
+	build = func(parent int32, seen map[int32]bool) []NotebookNode {
+		nodes := []NotebookNode{}
+		for _, nb := range children[parent] {
+			if seen[nb.Id] {
+				continue
+			}
+			seen[nb.Id] = true
+			nodes = append(nodes, NotebookNode{Notebook: nb, Children: build(nb.Id, seen)})
+		}
+		return nodes
+	}
+
+	return build(0, map[int32]bool{})
+}
+
+/*
+- Gets the notebooks owned by a user
+*/
+func ownedNotebooks(user User, notebooks []Notebook) []Notebook {
+	owned := []Notebook{}
+	for _, nb := range notebooks {
+		if nb.Owner == user.Id {
+			owned = append(owned, nb)
+		}
+	}
+	return owned
+}
+
+/*
+- Checks that a notebook can be used as the parent of another (or hold a note)
+Args:
+
+	user: owner of the notebook being moved
+	parentId: proposed parent, 0 for the top level
+	movingId: notebook being moved, 0 when placing a note
+	notebooks: every notebook
+
+return: true if the parent is owned by the user and isn't the notebook or one of its children
+*/
+func isValidNotebookParent(user User, parentId, movingId int32, notebooks []Notebook) bool {
+	if parentId == 0 {
+		return true
+	}
+
+	byId := make(map[int32]Notebook, len(notebooks))
+	for _, nb := range notebooks {
+		byId[nb.Id] = nb
+	}
+
+	parent, ok := byId[parentId]
+	if !ok || parent.Owner != user.Id {
+		return false
+	}
+
+	// The parent can't be inside the notebook being moved
+	seen := map[int32]bool{}
+	for nb, ok := parent, true; ok && !seen[nb.Id]; nb, ok = byId[nb.Parent] {
+		if movingId != 0 && nb.Id == movingId {
+			return false
+		}
+		seen[nb.Id] = true
+	}
+
+	return true
+}
+
+/*
+- Reads the notebook fields shared by the create and edit forms
+Args:
+
+	prefix: input name prefix (e.g. 'notebook-create')
+	otherUsers: list of users excluding the current one
+	r: http request
+
+return: name, parent id and share list
+*/
+func getNotebookForm(prefix string, otherUsers []User, r *http.Request) (string, int32, pq.Int32Array) {
+	nameRaw := r.FormValue(prefix + "-name")
+	name := truncateRunes(nameRaw, NoteNameMaxLength)
+
+	parent, _ := strconv.Atoi(r.FormValue(prefix + "-parent"))
+
+	r.ParseForm()
+	var ids []int32
+	for _, v := range r.Form[prefix+"-share"] {
+		if id, err := strconv.Atoi(v); err == nil {
+			ids = append(ids, int32(id))
+		}
+	}
+
+	return name, int32(parent), filterShareIds(ids, otherUsers)
+}
+
+func (a *App) createNotebookHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAuthenticated(w, r) {
+		return
+	}
+
+	user, err := a.fetchCurrentUser(r)
+	checkInternalServerError(err, w)
+
+	otherUsers, err := a.fetchUsersExclude(user)
+	checkInternalServerError(err, w)
+
+	notebooks, err := a.fetchNotebooks()
+	checkInternalServerError(err, w)
+
+	name, parent, share := getNotebookForm("notebook-create", otherUsers, r)
+	if name == "" || !isValidNotebookParent(user, parent, 0, notebooks) {
+		setFlash(w, FlashDashboard, "A notebook needs a name and a parent notebook you own")
+		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		return
+	}
+
+	_, err = a.db.Exec("INSERT INTO notebooks(notebook_owner, notebook_parent, notebook_name, notebook_share) VALUES($1, NULLIF($2, 0), $3, $4)",
+		user.Id, parent, name, share)
+	checkInternalServerError(err, w)
+
+	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+}
+
+/*
+- Gets the notebook in the request path if the current user owns it
+Args:
+
+	w: http response writer
+	r: http request
+	user: current user
+	notebooks: every notebook
+
+return: the notebook and true, or false if a response has already been sent
+*/
+func ownedNotebookFromPath(w http.ResponseWriter, r *http.Request, user User, notebooks []Notebook) (Notebook, bool) {
+	id, err := getIdFromPath(r)
+	if err != nil {
+		http.Error(w, "invalid notebook id", http.StatusBadRequest)
+		return Notebook{}, false
+	}
+
+	for _, nb := range notebooks {
+		if nb.Id == id {
+			if nb.Owner != user.Id {
+				forbidden(w)
+				return Notebook{}, false
+			}
+			return nb, true
+		}
+	}
+
+	http.NotFound(w, r)
+	return Notebook{}, false
+}
+
+func (a *App) editNotebookHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAuthenticated(w, r) {
+		return
+	}
+
+	user, err := a.fetchCurrentUser(r)
+	checkInternalServerError(err, w)
+
+	otherUsers, err := a.fetchUsersExclude(user)
+	checkInternalServerError(err, w)
+
+	notebooks, err := a.fetchNotebooks()
+	checkInternalServerError(err, w)
+
+	notebook, ok := ownedNotebookFromPath(w, r, user, notebooks)
+	if !ok {
+		return
+	}
+
+	name, parent, share := getNotebookForm("notebook-edit", otherUsers, r)
+	if name == "" || !isValidNotebookParent(user, parent, notebook.Id, notebooks) {
+		setFlash(w, FlashDashboard, "A notebook needs a name and can't be moved inside itself")
+		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		return
+	}
+
+	_, err = a.db.Exec("UPDATE notebooks SET notebook_parent=NULLIF($1, 0), notebook_name=$2, notebook_share=$3 WHERE notebook_id=$4",
+		parent, name, share, notebook.Id)
+	checkInternalServerError(err, w)
+
+	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+}
+
+/*
+- Deletes a notebook, its notes and child notebooks are moved up to its parent
+*/
+func (a *App) deleteNotebookHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAuthenticated(w, r) {
+		return
+	}
+
+	user, err := a.fetchCurrentUser(r)
+	checkInternalServerError(err, w)
+
+	notebooks, err := a.fetchNotebooks()
+	checkInternalServerError(err, w)
+
+	notebook, ok := ownedNotebookFromPath(w, r, user, notebooks)
+	if !ok {
+		return
+	}
+
+	err = func() error {
+		tx, err := a.db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		parent := sql.NullInt32{Int32: notebook.Parent, Valid: notebook.Parent != 0}
+
+		if _, err = tx.Exec("UPDATE notes SET note_notebook=$1 WHERE note_notebook=$2", parent, notebook.Id); err != nil {
+			return err
+		}
+		if _, err = tx.Exec("UPDATE notebooks SET notebook_parent=$1 WHERE notebook_parent=$2", parent, notebook.Id); err != nil {
+			return err
+		}
+		if _, err = tx.Exec("DELETE FROM notebooks WHERE notebook_id=$1", notebook.Id); err != nil {
+			return err
+		}
+
+		return tx.Commit()
+	}()
+	checkInternalServerError(err, w)
+
+	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+}
+
+// GET /api/v1/notebooks
+func (a *App) apiListNotebooksHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.apiCurrentUser(w, r)
+	if !ok {
+		return
+	}
+
+	notebooks, err := a.fetchNotebooks()
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	byId := make(map[int32]Notebook, len(notebooks))
+	for _, nb := range notebooks {
+		byId[nb.Id] = nb
+	}
+
+	apiNotebooks := []apiNotebook{}
+	for _, nb := range notebooks {
+		if !canViewNotebook(user, nb, byId) {
+			continue
+		}
+
+		share := []int32(nb.Share)
+		if share == nil {
+			share = []int32{}
+		}
+		apiNotebooks = append(apiNotebooks, apiNotebook{Id: nb.Id, Owner: nb.Owner, Parent: nb.Parent, Name: nb.Name, Share: share})
+	}
+
+	writeJSON(w, http.StatusOK, apiNotebooks)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+// Notebooks owned by user 1: work > projects > launch, and home. User 2 owns shared, which is shared with user 3
+var testNotebooks = []Notebook{
+	{Id: 1, Owner: 1, Name: "work"},
+	{Id: 2, Owner: 1, Parent: 1, Name: "projects"},
+	{Id: 3, Owner: 1, Parent: 2, Name: "launch"},
+	{Id: 4, Owner: 1, Name: "home"},
+	{Id: 5, Owner: 2, Name: "shared", Share: pq.Int32Array{3}},
+	{Id: 6, Owner: 2, Parent: 5, Name: "inside shared"},
+}
+
+func TestIsValidNotebookParent(t *testing.T) {
+	owner := User{Id: 1}
+
+	tests := []struct {
+		name     string
+		user     User
+		parentId int32
+		movingId int32
+		want     bool
+	}{
+		{"top level", owner, 0, 2, true},
+		{"note in a notebook", owner, 3, 0, true},
+		{"another of the user's notebooks", owner, 4, 2, true},
+		{"under its own parent", owner, 1, 2, true},
+		{"into itself", owner, 2, 2, false},
+		{"into its child", owner, 3, 2, false},
+		{"into its grandchild", owner, 3, 1, false},
+		{"someone else's notebook", owner, 5, 2, false},
+		{"shared with the user isn't enough", User{Id: 3}, 5, 0, false},
+		{"missing notebook", owner, 99, 0, false},
+	}
+
+	for _, tt := range tests {
+		if got := isValidNotebookParent(tt.user, tt.parentId, tt.movingId, testNotebooks); got != tt.want {
+			t.Errorf("%s: isValidNotebookParent(%d, %d) = %v, want %v", tt.name, tt.parentId, tt.movingId, got, tt.want)
+		}
+	}
+}
+
+func TestNotebookCyclesTerminate(t *testing.T) {
+	// Bad data where 1 and 2 are each other's parent
+	cycle := []Notebook{
+		{Id: 1, Owner: 1, Parent: 2, Name: "a"},
+		{Id: 2, Owner: 1, Parent: 1, Name: "b"},
+		{Id: 3, Owner: 1, Name: "c"},
+	}
+	byId := map[int32]Notebook{1: cycle[0], 2: cycle[1], 3: cycle[2]}
+
+	if !isValidNotebookParent(User{Id: 1}, 1, 3, cycle) {
+		t.Error("isValidNotebookParent rejected a parent whose ancestors loop without reaching the moving notebook")
+	}
+	if isValidNotebookParent(User{Id: 1}, 1, 2, cycle) {
+		t.Error("isValidNotebookParent accepted a parent inside the moving notebook")
+	}
+	if canViewNotebook(User{Id: 9}, cycle[0], byId) {
+		t.Error("canViewNotebook gave access to a notebook in a cycle that isn't shared")
+	}
+	buildNotebookTree(User{Id: 1}, cycle)
+}
+
+func TestCanViewNotebook(t *testing.T) {
+	byId := map[int32]Notebook{}
+	for _, nb := range testNotebooks {
+		byId[nb.Id] = nb
+	}
+
+	tests := []struct {
+		name     string
+		user     User
+		notebook int32
+		want     bool
+	}{
+		{"owner", User{Id: 1}, 3, true},
+		{"shared", User{Id: 3}, 5, true},
+		{"inside a shared notebook", User{Id: 3}, 6, true},
+		{"not shared", User{Id: 3}, 1, false},
+	}
+
+	for _, tt := range tests {
+		if got := canViewNotebook(tt.user, byId[tt.notebook], byId); got != tt.want {
+			t.Errorf("%s: canViewNotebook(%d) = %v, want %v", tt.name, tt.notebook, got, tt.want)
+		}
+	}
+}
+
+func TestBuildNotebookTree(t *testing.T) {
+	// The notebook below a visible one keeps its place, a notebook whose parent can't be seen goes to the top level
+	notebooks := append(testNotebooks, Notebook{Id: 7, Owner: 3, Parent: 2, Name: "mine under hidden"})
+
+	names := func(nodes []NotebookNode) []string {
+		var walk func(nodes []NotebookNode, prefix string) []string
+		walk = func(nodes []NotebookNode, prefix string) []string {
+			out := []string{}
+			for _, n := range nodes {
+				out = append(out, prefix+n.Notebook.Name)
+				out = append(out, walk(n.Children, prefix+n.Notebook.Name+"/")...)
+			}
+			return out
+		}
+		return walk(nodes, "")
+	}
+
+	tests := []struct {
+		user User
+		want []string
+	}{
+		{User{Id: 1}, []string{"work", "work/projects", "work/projects/launch", "home"}},
+		{User{Id: 3}, []string{"shared", "shared/inside shared", "mine under hidden"}},
+		{User{Id: 9}, []string{}},
+	}
+
+	for _, tt := range tests {
+		if got := names(buildNotebookTree(tt.user, notebooks)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("buildNotebookTree for user %d = %q, want %q", tt.user.Id, got, tt.want)
+		}
+	}
+}
+
+func TestGetNotebookForm(t *testing.T) {
+	otherUsers := []User{{Id: 2}, {Id: 3}}
+
+	tests := []struct {
+		name      string
+		form      url.Values
+		wantName  string
+		wantShare pq.Int32Array
+	}{
+		{"nobody ticked", url.Values{"nb-name": {"work"}}, "work", pq.Int32Array{}},
+		{"ticked users", url.Values{"nb-name": {"work"}, "nb-share": {"2", "3"}}, "work", pq.Int32Array{2, 3}},
+		{"unknown users are dropped", url.Values{"nb-name": {"work"}, "nb-share": {"2", "9", "x"}}, "work", pq.Int32Array{2}},
+		{"long names are cut by character", url.Values{"nb-name": {strings.Repeat("é", NoteNameMaxLength+1)}},
+			strings.Repeat("é", NoteNameMaxLength), pq.Int32Array{}},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/notebooks", strings.NewReader(tt.form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		name, _, share := getNotebookForm("nb", otherUsers, r)
+		if name != tt.wantName || !reflect.DeepEqual(share, tt.wantShare) {
+			t.Errorf("%s: getNotebookForm = %q, %v, want %q, %v", tt.name, name, share, tt.wantName, tt.wantShare)
+		}
+	}
+}
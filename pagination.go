@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* - A column the notes can be sorted by - */
+type sortColumn struct {
+	expr  string // SQL expression, notes is the only table in scope
+	cast  string // type a cursor value is cast to
+	valid func(string) bool
+}
+
+var (
+	validText = func(s string) bool { return true }
+	validDate = func(s string) bool { _, err := time.Parse("2006-01-02", s); return err == nil }
+	validDue  = func(s string) bool { return s == "infinity" || validDate(s) }
+	validInt  = func(s string) bool { _, err := strconv.Atoi(s); return err == nil }
+	validReal = func(s string) bool { _, err := strconv.ParseFloat(s, 32); return err == nil }
+)
+
+// Sort orders accepted in the 'sort' query parameter
+var sortColumns = map[string]sortColumn{
+	"created":   {expr: "note_date", cast: "date", valid: validDate},
+	"completed": {expr: "note_completion_date", cast: "date", valid: validDate},
+	"name":      {expr: "note_name", cast: "text", valid: validText},
+	"flag":      {expr: "note_flag", cast: "int", valid: validInt},
+	// Notes without a due date sort after every note that has one
+	"due":      {expr: "COALESCE(note_due_date, 'infinity'::date)", cast: "date", valid: validDue},
+	"priority": {expr: "note_priority", cast: "int", valid: validInt},
+	"owner":    {expr: "(SELECT username FROM users WHERE user_id=notes.note_owner)", cast: "text", valid: validText},
+	// Only used for keyword searches, 'query' is the tsquery of the search
+	"relevance": {expr: "ts_rank(note_search, query)", cast: "real", valid: validReal},
+}
+
+/* - Which page of notes to show and how they are sorted - */
+type PageQuery struct {
+	Sort   string // key of sortColumns, empty for the default
+	Order  string // "asc", "desc" or empty for the default
+	Limit  int
+	After  string // cursor of the last note on the previous page
+	Before string // cursor of the first note on the next page
+}
+
+/*
+- Reads the page and sort order from a query string (e.g. /dashboard?sort=name&order=asc&limit=50&after=...)
+Args:
+
+	values: query string values
+
+return: the page, a missing or invalid sort order, limit or cursor uses the default
+*/
+func parsePageQuery(values url.Values) PageQuery {
+	page := PageQuery{
+		Sort:   values.Get("sort"),
+		Order:  values.Get("order"),
+		Limit:  DefaultPageSize,
+		After:  values.Get("after"),
+		Before: values.Get("before"),
+	}
+
+	if _, ok := sortColumns[page.Sort]; !ok {
+		page.Sort = ""
+	}
+	if page.Order != "asc" && page.Order != "desc" {
+		page.Order = ""
+	}
+	if n, err := strconv.Atoi(values.Get("limit")); err == nil && n > 0 {
+		page.Limit = minInt(n, MaxPageSize)
+	}
+
+	return page
+}
+
+/*
+- Encodes the search filters and page as a query string, only values that are set are included
+Args:
+
+	search: search filters
+
+return: query string beginning with '?' or an empty string
+*/
+func (p PageQuery) Encode(search SearchQuery) string {
+	values, _ := url.ParseQuery(strings.TrimPrefix(search.Encode(), "?"))
+	if p.Sort != "" {
+		values.Set("sort", p.Sort)
+	}
+	if p.Order != "" {
+		values.Set("order", p.Order)
+	}
+	if p.Limit != DefaultPageSize {
+		values.Set("limit", strconv.Itoa(p.Limit))
+	}
+	if p.After != "" {
+		values.Set("after", p.After)
+	}
+	if p.Before != "" {
+		values.Set("before", p.Before)
+	}
+
+	if len(values) == 0 {
+		return ""
+	}
+	return "?" + values.Encode()
+}
+
+/*
+- Chooses the sort order actually used
+Args:
+
+	hasKeyword: if the search has terms to rank by
+
+return: key of sortColumns and true for descending
+*/
+func (p PageQuery) resolveSort(hasKeyword bool) (string, bool) {
+	sort := p.Sort
+	if sort == "" || (sort == "relevance" && !hasKeyword) {
+		sort = "created"
+		if hasKeyword {
+			sort = "relevance"
+		}
+	}
+
+	desc := p.Order != "asc"
+	if p.Order == "" {
+		// Names and owners read best A-Z, due dates soonest first, everything else newest or best first
+		desc = sort != "name" && sort != "owner" && sort != "due"
+	}
+
+	return sort, desc
+}
+
+/*
+- Encodes the position of a note in a sort order, the sort is included so a cursor can't be used with another
+Args:
+
+	sort: key of sortColumns
+	value: the note's value of the sort column as text
+	id: note_id of the note
+
+return: the cursor
+*/
+func encodeCursor(sort, value string, id int32) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(sort + "\x00" + strconv.Itoa(int(id)) + "\x00" + value))
+}
+
+/*
+- Decodes a cursor made by encodeCursor
+Args:
+
+	cursor: cursor from the query string
+	sort: sort order in use
+
+return: the sort value, note_id and true, or false if the cursor is invalid or from another sort order
+*/
+func decodeCursor(cursor, sort string) (string, int32, bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", 0, false
+	}
+
+	column, ok := sortColumns[sort]
+	parts := strings.SplitN(string(raw), "\x00", 3)
+	if !ok || len(parts) != 3 || parts[0] != sort || !column.valid(parts[2]) {
+		return "", 0, false
+	}
+
+	id, err := strconv.ParseInt(parts[1], 10, 32)
+	if err != nil {
+		return "", 0, false
+	}
+
+	return parts[2], int32(id), true
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/url"
+	"testing"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	tests := []struct {
+		sort, value string
+		id          int32
+	}{
+		{"created", "2024-01-31", 1},
+		{"due", "infinity", 42},
+		{"name", "", 7},
+		{"name", "a\x00b, with a NUL", 8}, // only the first two separators split the cursor
+		{"owner", "ünïcode", 2147483647},
+		{"relevance", "0.0607927", 3},
+		{"priority", "3", 9},
+	}
+
+	for _, tt := range tests {
+		cursor := encodeCursor(tt.sort, tt.value, tt.id)
+		value, id, ok := decodeCursor(cursor, tt.sort)
+		if !ok || value != tt.value || id != tt.id {
+			t.Errorf("decodeCursor(encodeCursor(%q, %q, %d)) = %q, %d, %v", tt.sort, tt.value, tt.id, value, id, ok)
+		}
+	}
+}
+
+func TestDecodeBadCursor(t *testing.T) {
+	raw := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+
+	tests := []struct {
+		name, cursor, sort string
+	}{
+		{"empty", "", "created"},
+		{"not base64", "!!!", "created"},
+		{"other sort order", encodeCursor("name", "2024-01-01", 1), "created"},
+		{"unknown sort order", encodeCursor("colour", "red", 1), "colour"},
+		{"missing parts", raw("created\x002024-01-01"), "created"},
+		{"bad id", raw("created\x00one\x002024-01-01"), "created"},
+		{"id out of range", raw("created\x009999999999\x002024-01-01"), "created"},
+		{"bad date", encodeCursor("created", "yesterday", 1), "created"},
+		{"bad number", encodeCursor("priority", "high", 1), "priority"},
+		{"sql as a value", encodeCursor("flag", "1; DROP TABLE notes", 1), "flag"},
+	}
+
+	for _, tt := range tests {
+		if value, id, ok := decodeCursor(tt.cursor, tt.sort); ok {
+			t.Errorf("%s: decodeCursor(%q, %q) = %q, %d, want it rejected", tt.name, tt.cursor, tt.sort, value, id)
+		}
+	}
+}
+
+func TestResolveSort(t *testing.T) {
+	tests := []struct {
+		page       PageQuery
+		hasKeyword bool
+		wantSort   string
+		wantDesc   bool
+	}{
+		{PageQuery{}, false, "created", true},
+		{PageQuery{}, true, "relevance", true},
+		{PageQuery{Sort: "relevance"}, false, "created", true},
+		{PageQuery{Sort: "name"}, false, "name", false},
+		{PageQuery{Sort: "name", Order: "desc"}, false, "name", true},
+		{PageQuery{Sort: "due"}, true, "due", false},
+		{PageQuery{Sort: "priority", Order: "asc"}, false, "priority", false},
+	}
+
+	for _, tt := range tests {
+		sort, desc := tt.page.resolveSort(tt.hasKeyword)
+		if sort != tt.wantSort || desc != tt.wantDesc {
+			t.Errorf("%+v.resolveSort(%v) = %q, %v, want %q, %v", tt.page, tt.hasKeyword, sort, desc, tt.wantSort, tt.wantDesc)
+		}
+	}
+}
+
+func TestParsePageQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  PageQuery
+	}{
+		{"", PageQuery{Limit: DefaultPageSize}},
+		{"sort=name&order=asc&limit=10&after=abc", PageQuery{Sort: "name", Order: "asc", Limit: 10, After: "abc"}},
+		{"sort=colour&order=up&limit=-1", PageQuery{Limit: DefaultPageSize}},
+		{"limit=100000", PageQuery{Limit: MaxPageSize}},
+	}
+
+	for _, tt := range tests {
+		values, _ := url.ParseQuery(tt.query)
+		if got := parsePageQuery(values); got != tt.want {
+			t.Errorf("parsePageQuery(%q) = %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+}
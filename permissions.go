@@ -0,0 +1,330 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Note access levels, ordered so that a higher level includes the lower ones.
+// Viewer to co-owner are the share roles stored in note_shares, so they must not be renumbered
+const (
+	NoteAccessNone = iota
+	NoteAccessViewer
+	NoteAccessCommenter
+	NoteAccessEditor
+	NoteAccessCoOwner
+	NoteAccessOwner
+)
+
+/*
+- Works out what a user is allowed to do with a note
+  - owner: the user created the note
+  - viewer, commenter, editor or co-owner: the note is shared with the user, or a group they are in, with that role
+  - viewer: the note's notebook is shared with the user or the note is visible to everyone
+
+Args:
+
+	user: user requesting access
+	note: note being accessed
+
+return: one of the NoteAccess* levels
+*/
+func noteAccessLevel(user User, note Note) int {
+	if note.Owner == user.Id {
+		return NoteAccessOwner
+	}
+
+	level := NoteAccessNone
+	if slices.Contains(note.Share, user.Id) {
+		level = note.ShareRoles.role(user.Id)
+	}
+
+	// Members of a group get the group's role, the highest role they are given wins
+	if _, ok := note.GroupRoles[user.Id]; ok {
+		level = max(level, note.GroupRoles.role(user.Id))
+	}
+
+	if level != NoteAccessNone {
+		return level
+	}
+
+	if note.Visibility == NoteVisibilityEveryone {
+		return NoteAccessViewer
+	}
+
+	// Sharing a notebook shares every note inside it
+	for _, shareId := range note.NotebookShare {
+		if shareId == user.Id {
+			return NoteAccessViewer
+		}
+	}
+
+	return NoteAccessNone
+}
+
+/*
+- SQL condition matching the notes a user can view, the same rules as noteAccessLevel
+Args:
+
+	userArg: placeholder holding the user_id (e.g. '$1')
+
+return: condition for a query on the notes table
+*/
+func noteVisibleCondition(userArg string) string {
+	return "(note_owner=" + userArg +
+		" OR note_visibility=" + strconv.Itoa(NoteVisibilityEveryone) +
+		" OR " + userArg + "=ANY(note_share)" +
+		" OR EXISTS (SELECT 1 FROM note_group_shares gs JOIN group_members gm ON gm.group_id=gs.group_id WHERE gs.note_id=notes.note_id AND gm.user_id=" + userArg + ")" +
+		" OR " + userArg + "=ANY(" + noteNotebookShareColumn + "))"
+}
+
+func canViewNote(user User, note Note) bool {
+	return noteAccessLevel(user, note) >= NoteAccessViewer
+}
+
+func canCommentNote(user User, note Note) bool {
+	return noteAccessLevel(user, note) >= NoteAccessCommenter
+}
+
+func canEditNote(user User, note Note) bool {
+	return noteAccessLevel(user, note) >= NoteAccessEditor
+}
+
+// Deleted notes go to the owner's trash
+func canDeleteNote(user User, note Note) bool {
+	return noteAccessLevel(user, note) >= NoteAccessCoOwner
+}
+
+// Co-owners can change who a note is shared with and their roles
+func canShareNote(user User, note Note) bool {
+	return noteAccessLevel(user, note) >= NoteAccessCoOwner
+}
+
+// Notebooks belong to a single user so only the owner can move a note between them
+func canMoveNote(user User, note Note) bool {
+	return noteAccessLevel(user, note) >= NoteAccessOwner
+}
+
+/*
+- Gets the role of a user a note is shared with, viewer if no role was recorded
+*/
+func (roles ShareRoles) role(id int32) int {
+	if role, ok := roles[id]; ok && isValidShareRole(role) {
+		return role
+	}
+	return NoteAccessViewer
+}
+
+/*
+- Checks that a share role is one of viewer, commenter, editor or co-owner
+*/
+func isValidShareRole(role int) bool {
+	return role >= NoteAccessViewer && role <= NoteAccessCoOwner
+}
+
+/*
+- Gets the display name of a share role, also used by the api
+*/
+func shareRoleToString(role int) string {
+	return []string{
+		"none",
+		"viewer",
+		"commenter",
+		"editor",
+		"co-owner",
+		"owner",
+	}[role]
+}
+
+/*
+- Reads a share role by name
+return: the role and true, or false if there is no such role
+*/
+func parseShareRole(name string) (int, bool) {
+	for role := NoteAccessViewer; role <= NoteAccessCoOwner; role++ {
+		if shareRoleToString(role) == strings.ToLower(name) {
+			return role, true
+		}
+	}
+	return 0, false
+}
+
+/*
+- Checks that a note visibility is one of the NoteVisibility* constants
+*/
+func isValidNoteVisibility(visibility int) bool {
+	return visibility >= 0 && visibility < NoteVisibilityMax
+}
+
+/*
+- Gets the name of a note visibility, also used by the api
+*/
+func noteVisibilityToString(visibility int) string {
+	return []string{
+		"private",
+		"shared",
+		"everyone",
+	}[visibility]
+}
+
+/*
+- Reads a note visibility by name
+return: the visibility and true, or false if there is no such visibility
+*/
+func parseNoteVisibility(name string) (int, bool) {
+	for visibility := 0; visibility < NoteVisibilityMax; visibility++ {
+		if noteVisibilityToString(visibility) == strings.ToLower(name) {
+			return visibility, true
+		}
+	}
+	return 0, false
+}
+
+/*
+- Makes a note's share list agree with its visibility, private notes aren't shared with any users or groups
+and a note shared with nobody is private
+Args:
+
+	note: note with its visibility and share list picked
+
+return: the note as it should be saved
+*/
+func normaliseVisibility(note Note) Note {
+	switch {
+	case note.Visibility == NoteVisibilityPrivate:
+		note.Share = pq.Int32Array{}
+		note.ShareRoles = ShareRoles{}
+		note.GroupShares = ShareRoles{}
+		note.GroupRoles = ShareRoles{}
+	case note.Visibility == NoteVisibilityShared && len(note.Share) == 0 && len(note.GroupShares) == 0:
+		note.Visibility = NoteVisibilityPrivate
+	}
+	return note
+}
+
+/*
+- Checks the visibility a user picked for a note, the note forms and the api refuse the same things
+Args:
+
+	note: note with the picked visibility
+	user: user that picked it
+	shared: users or groups were picked to share the note with
+
+return: an error message, or an empty string if the note can be saved
+*/
+func checkVisibility(note Note, user User, shared bool) string {
+	if note.Visibility != NoteVisibilityPrivate {
+		return ""
+	}
+
+	// Only the owner can see a private note, a co-owner would lose it
+	if note.Owner != user.Id {
+		return "only the owner can make a note private"
+	}
+	if shared {
+		return "private notes can't be shared, pick shared or everyone"
+	}
+	return ""
+}
+
+/*
+- Keeps a co-owner in the share list they just edited, co-owners aren't offered themselves when sharing
+so they would otherwise lose access to the note. Co-owners through a group that is still shared are left as they are
+Args:
+
+	note: note with the edited share list and roles
+	user: user that edited them
+
+return: the note with the user added back as a co-owner if they would no longer be one
+*/
+func keepCoOwner(note Note, user User) Note {
+	if noteAccessLevel(user, note) >= NoteAccessCoOwner || slices.Contains(note.Share, user.Id) {
+		return note
+	}
+
+	// The note is no longer private once the co-owner is added back
+	if note.Visibility == NoteVisibilityPrivate {
+		note.Visibility = NoteVisibilityShared
+	}
+	note.Share = append(note.Share, user.Id)
+
+	if note.ShareRoles == nil {
+		note.ShareRoles = ShareRoles{}
+	}
+	note.ShareRoles[user.Id] = NoteAccessCoOwner
+
+	return note
+}
+
+/*
+- Replaces the share roles of a note and the groups it is shared with, only users the note is shared with keep a role
+Args:
+
+	tx: transaction the note is being written in
+	note: note as it is being saved
+
+return: nil or an error
+*/
+func setNoteShares(tx *sql.Tx, note Note) error {
+	_, err := tx.Exec("DELETE FROM note_shares WHERE note_id=$1", note.Id)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range note.Share {
+		_, err = tx.Exec("INSERT INTO note_shares(note_id, user_id, share_role) VALUES($1, $2, $3) ON CONFLICT DO NOTHING",
+			note.Id, id, note.ShareRoles.role(id))
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec("DELETE FROM note_group_shares WHERE note_id=$1", note.Id)
+	if err != nil {
+		return err
+	}
+
+	for id := range note.GroupShares {
+		_, err = tx.Exec("INSERT INTO note_group_shares(note_id, group_id, share_role) VALUES($1, $2, $3) ON CONFLICT DO NOTHING",
+			note.Id, id, note.GroupShares.role(id))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+- Reads the admins from ADMIN_USERS, a comma separated list of usernames
+return: list of usernames, empty if ADMIN_USERS isn't set
+*/
+func findAdmins() []string {
+	admins := []string{}
+	for _, name := range strings.Split(os.Getenv("ADMIN_USERS"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			admins = append(admins, name)
+		}
+	}
+	return admins
+}
+
+/*
+- Checks if a user is an admin, only admins can change the workflow
+*/
+func (a *App) isAdmin(user User) bool {
+	return slices.Contains(a.admins, user.Username)
+}
+
+/*
+- Sends a 403 to the client
+*/
+func forbidden(w http.ResponseWriter) {
+	http.Error(w, "You do not have permission to do that", http.StatusForbidden)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestNoteAccessLevel(t *testing.T) {
+	note := Note{
+		Owner:         1,
+		Share:         pq.Int32Array{2, 3},
+		ShareRoles:    ShareRoles{2: NoteAccessEditor, 3: NoteAccessOwner},
+		GroupRoles:    ShareRoles{2: NoteAccessCoOwner, 4: NoteAccessCommenter},
+		NotebookShare: pq.Int32Array{5},
+		Visibility:    NoteVisibilityShared,
+	}
+
+	tests := []struct {
+		name string
+		user int32
+		note Note
+		want int
+	}{
+		{"owner", 1, note, NoteAccessOwner},
+		{"higher of the user and group roles", 2, note, NoteAccessCoOwner},
+		{"invalid role is a viewer", 3, note, NoteAccessViewer},
+		{"group role", 4, note, NoteAccessCommenter},
+		{"notebook share", 5, note, NoteAccessViewer},
+		{"not shared", 6, note, NoteAccessNone},
+		{"everyone", 6, Note{Owner: 1, Visibility: NoteVisibilityEveryone}, NoteAccessViewer},
+	}
+
+	for _, tt := range tests {
+		if got := noteAccessLevel(User{Id: tt.user}, tt.note); got != tt.want {
+			t.Errorf("%s: noteAccessLevel(%d) = %d, want %d", tt.name, tt.user, got, tt.want)
+		}
+	}
+}
+
+func TestNormaliseVisibility(t *testing.T) {
+	shared := Note{
+		Share:       pq.Int32Array{2},
+		ShareRoles:  ShareRoles{2: NoteAccessEditor},
+		GroupShares: ShareRoles{1: NoteAccessViewer},
+		GroupRoles:  ShareRoles{3: NoteAccessViewer},
+	}
+	cleared := Note{Share: pq.Int32Array{}, ShareRoles: ShareRoles{}, GroupShares: ShareRoles{}, GroupRoles: ShareRoles{}}
+
+	withVisibility := func(note Note, visibility int) Note {
+		note.Visibility = visibility
+		return note
+	}
+
+	tests := []struct {
+		name string
+		note Note
+		want Note
+	}{
+		{"private clears the shares", withVisibility(shared, NoteVisibilityPrivate), withVisibility(cleared, NoteVisibilityPrivate)},
+		{"shared keeps them", withVisibility(shared, NoteVisibilityShared), withVisibility(shared, NoteVisibilityShared)},
+		{"everyone keeps them", withVisibility(shared, NoteVisibilityEveryone), withVisibility(shared, NoteVisibilityEveryone)},
+		{"shared with nobody is private", Note{Visibility: NoteVisibilityShared}, Note{Visibility: NoteVisibilityPrivate}},
+		{"shared with a group only", Note{Visibility: NoteVisibilityShared, GroupShares: ShareRoles{1: NoteAccessViewer}},
+			Note{Visibility: NoteVisibilityShared, GroupShares: ShareRoles{1: NoteAccessViewer}}},
+		{"everyone with nobody stays", Note{Visibility: NoteVisibilityEveryone}, Note{Visibility: NoteVisibilityEveryone}},
+	}
+
+	for _, tt := range tests {
+		if got := normaliseVisibility(tt.note); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: normaliseVisibility = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestKeepCoOwner(t *testing.T) {
+	coOwner := User{Id: 2}
+
+	tests := []struct {
+		name string
+		note Note
+		want Note
+	}{
+		{"still a co-owner", Note{Owner: 1, Visibility: NoteVisibilityShared, Share: pq.Int32Array{2}, ShareRoles: ShareRoles{2: NoteAccessCoOwner}},
+			Note{Owner: 1, Visibility: NoteVisibilityShared, Share: pq.Int32Array{2}, ShareRoles: ShareRoles{2: NoteAccessCoOwner}}},
+		{"a lower role they picked is kept", Note{Owner: 1, Visibility: NoteVisibilityShared, Share: pq.Int32Array{2}, ShareRoles: ShareRoles{2: NoteAccessViewer}},
+			Note{Owner: 1, Visibility: NoteVisibilityShared, Share: pq.Int32Array{2}, ShareRoles: ShareRoles{2: NoteAccessViewer}}},
+		{"added back", Note{Owner: 1, Visibility: NoteVisibilityShared, Share: pq.Int32Array{3}, ShareRoles: ShareRoles{3: NoteAccessEditor}},
+			Note{Owner: 1, Visibility: NoteVisibilityShared, Share: pq.Int32Array{3, 2}, ShareRoles: ShareRoles{2: NoteAccessCoOwner, 3: NoteAccessEditor}}},
+		{"a co-owner through a group is left alone", Note{Owner: 1, Visibility: NoteVisibilityShared, GroupRoles: ShareRoles{2: NoteAccessCoOwner}},
+			Note{Owner: 1, Visibility: NoteVisibilityShared, GroupRoles: ShareRoles{2: NoteAccessCoOwner}}},
+		{"a private note is shared again", Note{Owner: 1, Visibility: NoteVisibilityPrivate},
+			Note{Owner: 1, Visibility: NoteVisibilityShared, Share: pq.Int32Array{2}, ShareRoles: ShareRoles{2: NoteAccessCoOwner}}},
+		{"the owner is never added", Note{Owner: 2, Visibility: NoteVisibilityPrivate}, Note{Owner: 2, Visibility: NoteVisibilityPrivate}},
+	}
+
+	for _, tt := range tests {
+		if got := keepCoOwner(tt.note, coOwner); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: keepCoOwner = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCheckVisibility(t *testing.T) {
+	owner, coOwner := User{Id: 1}, User{Id: 2}
+
+	tests := []struct {
+		name       string
+		visibility int
+		user       User
+		shared     bool
+		want       string
+	}{
+		{"owner makes it private", NoteVisibilityPrivate, owner, false, ""},
+		{"private with users picked", NoteVisibilityPrivate, owner, true, "private notes can't be shared, pick shared or everyone"},
+		{"co-owner makes it private", NoteVisibilityPrivate, coOwner, false, "only the owner can make a note private"},
+		{"co-owner shares it", NoteVisibilityShared, coOwner, true, ""},
+		{"everyone", NoteVisibilityEveryone, owner, true, ""},
+	}
+
+	for _, tt := range tests {
+		note := Note{Owner: owner.Id, Visibility: tt.visibility}
+		if got := checkVisibility(note, tt.user, tt.shared); got != tt.want {
+			t.Errorf("%s: checkVisibility = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseNoteVisibility(t *testing.T) {
+	for visibility := 0; visibility < NoteVisibilityMax; visibility++ {
+		name := noteVisibilityToString(visibility)
+		if got, ok := parseNoteVisibility(name); !ok || got != visibility {
+			t.Errorf("parseNoteVisibility(%q) = %d, %v, want %d, true", name, got, ok, visibility)
+		}
+	}
+	if _, ok := parseNoteVisibility("public"); ok {
+		t.Error("parseNoteVisibility accepted 'public'")
+	}
+}
@@ -0,0 +1,503 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Kinds of token in a search query
+const (
+	tokenWord = iota
+	tokenPhrase
+	tokenField
+	tokenNot
+	tokenLParen
+	tokenRParen
+	tokenEnd
+)
+
+/* - A single token of a search query - */
+type queryToken struct {
+	kind  int
+	text  string // word, phrase or field name
+	value string // value of a field
+	pos   int
+}
+
+/* - A search query that couldn't be parsed, shown to the user as is - */
+type SearchError struct {
+	Pos int // characters (not bytes) from the start of the query
+	Msg string
+}
+
+func (e *SearchError) Error() string {
+	return fmt.Sprintf("%s (at character %d)", e.Msg, e.Pos+1)
+}
+
+/*
+- Turns the byte offset the lexer and parser report an error at into a character offset
+Args:
+
+	query: search as typed by the user
+	err: error returned while compiling query
+
+return: the error with its position in characters
+*/
+func searchErrorInRunes(query string, err error) error {
+	if searchErr, ok := err.(*SearchError); ok {
+		searchErr.Pos = utf8.RuneCountInString(query[:minInt(searchErr.Pos, len(query))])
+	}
+	return err
+}
+
+/*
+- Checks if a word has anything full-text search can match
+*/
+func hasSearchableText(s string) bool {
+	return strings.IndexFunc(s, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) != -1
+}
+
+/*
+- Splits a search query into tokens
+Args:
+
+	query: search as typed by the user
+
+return: tokens ending with tokenEnd, or a *SearchError
+*/
+func lexQuery(query string) ([]queryToken, error) {
+	tokens := []queryToken{}
+	isSpace := func(i int) bool { return strings.ContainsRune(" \t\r\n", rune(query[i])) }
+
+	// Reads a quoted string starting at query[i], returns the text and the index after the closing quote
+	readQuoted := func(i int) (string, int, error) {
+		end := strings.IndexByte(query[i+1:], '"')
+		if end == -1 {
+			return "", 0, &SearchError{Pos: i, Msg: "missing closing quote"}
+		}
+		return query[i+1 : i+1+end], i + end + 2, nil
+	}
+
+	for i := 0; i < len(query); {
+		switch {
+		case isSpace(i):
+			i++
+		case query[i] == '(':
+			tokens = append(tokens, queryToken{kind: tokenLParen, pos: i})
+			i++
+		case query[i] == ')':
+			tokens = append(tokens, queryToken{kind: tokenRParen, pos: i})
+			i++
+		case query[i] == '-' && i+1 < len(query) && !isSpace(i+1):
+			tokens = append(tokens, queryToken{kind: tokenNot, pos: i})
+			i++
+		case query[i] == '"':
+			phrase, next, err := readQuoted(i)
+			if err != nil {
+				return nil, err
+			}
+			if hasSearchableText(phrase) {
+				tokens = append(tokens, queryToken{kind: tokenPhrase, text: phrase, pos: i})
+			}
+			i = next
+		default:
+			start := i
+			for i < len(query) && !isSpace(i) && !strings.ContainsRune("()\"", rune(query[i])) {
+				i++
+			}
+			word := query[start:i]
+
+			field, value, isField := strings.Cut(word, ":")
+			if !isField || field == "" {
+				if hasSearchableText(word) {
+					tokens = append(tokens, queryToken{kind: tokenWord, text: word, pos: start})
+				}
+				continue
+			}
+
+			// field:"quoted value"
+			if value == "" && i < len(query) && query[i] == '"' {
+				var err error
+				if value, i, err = readQuoted(i); err != nil {
+					return nil, err
+				}
+			}
+			if value == "" {
+				return nil, &SearchError{Pos: start, Msg: "missing value for '" + field + ":'"}
+			}
+			tokens = append(tokens, queryToken{kind: tokenField, text: strings.ToLower(field), value: value, pos: start})
+		}
+	}
+
+	return append(tokens, queryToken{kind: tokenEnd, pos: len(query)}), nil
+}
+
+/* - Compiles a search query into a SQL condition while it is parsed - */
+type queryCompiler struct {
+	tokens   []queryToken
+	pos      int
+	user     User
+	workflow Workflow
+	args     []any
+	rank     []string // tsqueries of the terms that aren't negated, used to rank and highlight
+	negated  bool
+}
+
+func (c *queryCompiler) arg(v any) string {
+	c.args = append(c.args, v)
+	return "$" + strconv.Itoa(len(c.args))
+}
+
+func (c *queryCompiler) peek() queryToken {
+	return c.tokens[c.pos]
+}
+
+func (c *queryCompiler) next() queryToken {
+	tok := c.tokens[c.pos]
+	if tok.kind != tokenEnd {
+		c.pos++
+	}
+	return tok
+}
+
+func isQueryKeyword(tok queryToken, keyword string) bool {
+	return tok.kind == tokenWord && strings.EqualFold(tok.text, keyword)
+}
+
+// or := and ('OR' and)*
+func (c *queryCompiler) parseOr() (string, error) {
+	parts := []string{}
+	for {
+		part, err := c.parseAnd()
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, part)
+
+		if !isQueryKeyword(c.peek(), "OR") {
+			break
+		}
+		c.next()
+
+		if next := c.peek(); next.kind == tokenEnd || next.kind == tokenRParen {
+			return "", &SearchError{Pos: next.pos, Msg: "OR needs a search term after it"}
+		}
+	}
+
+	if len(parts) == 1 {
+		return parts[0], nil
+	}
+	return "(" + strings.Join(parts, " OR ") + ")", nil
+}
+
+// and := not (['AND'] not)*
+func (c *queryCompiler) parseAnd() (string, error) {
+	parts := []string{}
+	for {
+		tok := c.peek()
+		if tok.kind == tokenEnd || tok.kind == tokenRParen || isQueryKeyword(tok, "OR") {
+			break
+		}
+		if isQueryKeyword(tok, "AND") {
+			if len(parts) == 0 {
+				return "", &SearchError{Pos: tok.pos, Msg: "AND needs a search term before it"}
+			}
+			c.next()
+			if next := c.peek(); next.kind == tokenEnd || next.kind == tokenRParen || isQueryKeyword(next, "OR") {
+				return "", &SearchError{Pos: next.pos, Msg: "AND needs a search term after it"}
+			}
+			continue
+		}
+
+		part, err := c.parseNot()
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, part)
+	}
+
+	if len(parts) == 0 {
+		tok := c.peek()
+		if isQueryKeyword(tok, "OR") {
+			return "", &SearchError{Pos: tok.pos, Msg: "OR needs a search term on both sides"}
+		}
+		return "", &SearchError{Pos: tok.pos, Msg: "expected a search term"}
+	}
+
+	if len(parts) == 1 {
+		return parts[0], nil
+	}
+	return "(" + strings.Join(parts, " AND ") + ")", nil
+}
+
+// not := ('NOT' | '-') not | primary
+func (c *queryCompiler) parseNot() (string, error) {
+	tok := c.peek()
+	if tok.kind != tokenNot && !isQueryKeyword(tok, "NOT") {
+		return c.parsePrimary()
+	}
+	c.next()
+
+	c.negated = !c.negated
+	inner, err := c.parseNot()
+	c.negated = !c.negated
+	if err != nil {
+		return "", err
+	}
+
+	return "NOT (" + inner + ")", nil
+}
+
+// primary := '(' or ')' | field:value | "phrase" | word
+func (c *queryCompiler) parsePrimary() (string, error) {
+	tok := c.next()
+	switch tok.kind {
+	case tokenLParen:
+		inner, err := c.parseOr()
+		if err != nil {
+			return "", err
+		}
+		if end := c.next(); end.kind != tokenRParen {
+			return "", &SearchError{Pos: tok.pos, Msg: "missing closing ')'"}
+		}
+		return "(" + inner + ")", nil
+	case tokenField:
+		return c.compileField(tok)
+	case tokenWord, tokenPhrase:
+		return c.compileText(tok), nil
+	default:
+		return "", &SearchError{Pos: tok.pos, Msg: "expected a search term"}
+	}
+}
+
+/*
+- Compiles a word or phrase into a full-text match, a trailing '*' matches words starting with it
+*/
+func (c *queryCompiler) compileText(tok queryToken) string {
+	words := strings.FieldsFunc(tok.text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	// Only letters and digits reach the tsquery so user input can't break its syntax
+	tsQuery := strings.Join(words, " <-> ")
+	if strings.HasSuffix(tok.text, "*") {
+		tsQuery += ":*"
+	}
+
+	if !c.negated {
+		c.rank = append(c.rank, tsQuery)
+	}
+
+	return "note_search @@ to_tsquery('english', " + c.arg(tsQuery) + ")"
+}
+
+/*
+- Compiles a field:value filter
+*/
+func (c *queryCompiler) compileField(tok queryToken) (string, error) {
+	switch tok.text {
+	case "owner", "user":
+		if strings.EqualFold(tok.value, "me") {
+			return "note_owner=" + c.arg(c.user.Id), nil
+		}
+		return "note_owner IN (SELECT user_id FROM users WHERE username=" + c.arg(tok.value) + ")", nil
+	case "assignee", "delegated":
+		if strings.EqualFold(tok.value, "none") {
+			return "COALESCE(cardinality(note_assignees), 0)=0", nil
+		}
+		if strings.EqualFold(tok.value, "me") {
+			return c.arg(c.user.Id) + "=ANY(note_assignees)", nil
+		}
+		return "note_assignees && ARRAY(SELECT user_id FROM users WHERE username=" + c.arg(tok.value) + ")", nil
+	case "flag", "status":
+		flag, ok := c.workflow.findState(tok.value)
+		if !ok {
+			names := []string{}
+			for _, state := range c.workflow.States {
+				names = append(names, "'"+state.Name+"'")
+			}
+			return "", &SearchError{Pos: tok.pos, Msg: "unknown status '" + tok.value + "', expected one of " + strings.Join(names, ", ")}
+		}
+		return "note_flag=" + c.arg(flag), nil
+	case "is":
+		switch strings.ToLower(tok.value) {
+		case "open":
+			return "NOT (" + terminalStateCondition + ")", nil
+		case "closed":
+			return terminalStateCondition, nil
+		}
+		return "", &SearchError{Pos: tok.pos, Msg: "unknown value '" + tok.value + "' for 'is:', expected open or closed"}
+	case "tag":
+		return "EXISTS (SELECT 1 FROM note_tags nt JOIN tags t ON t.tag_id=nt.tag_id WHERE nt.note_id=notes.note_id AND t.tag_name=" + c.arg(normaliseTag(tok.value)) + ")", nil
+	case "notebook":
+		if strings.EqualFold(tok.value, "none") {
+			return "note_notebook IS NULL", nil
+		}
+		if id, err := strconv.Atoi(tok.value); err == nil {
+			return "COALESCE(note_notebook, 0)=" + c.arg(id), nil
+		}
+		return "COALESCE(note_notebook, 0) IN (SELECT notebook_id FROM notebooks WHERE lower(notebook_name)=lower(" + c.arg(tok.value) + "))", nil
+	case "group":
+		if strings.EqualFold(tok.value, "none") {
+			return "NOT EXISTS (SELECT 1 FROM note_group_shares WHERE note_id=notes.note_id)", nil
+		}
+		return "EXISTS (SELECT 1 FROM note_group_shares gs JOIN user_groups g ON g.group_id=gs.group_id WHERE gs.note_id=notes.note_id AND lower(g.group_name)=lower(" + c.arg(tok.value) + "))", nil
+	case "created":
+		return c.compileDate(tok, "note_date")
+	case "completed":
+		cond, err := c.compileDate(tok, "note_completion_date")
+		if err != nil {
+			return "", err
+		}
+		return "(" + c.workflow.completedCondition() + " AND " + cond + ")", nil
+	case "due":
+		return c.compileDue(tok)
+	case "priority":
+		return c.compilePriority(tok)
+	}
+
+	return "", &SearchError{Pos: tok.pos, Msg: "unknown filter '" + tok.text + ":', expected owner, assignee, flag, is, tag, notebook, group, created, completed, due or priority"}
+}
+
+/*
+- Compiles a date filter: 2024-01-01, >2024-01-01, >=, <, <= or a range 2024-01-01..2024-01-31
+*/
+func (c *queryCompiler) compileDate(tok queryToken, column string) (string, error) {
+	checkDate := func(s string) (string, error) {
+		if _, err := time.Parse("2006-01-02", s); err != nil {
+			return "", &SearchError{Pos: tok.pos, Msg: "invalid date '" + s + "' for '" + tok.text + ":', dates are written as YYYY-MM-DD"}
+		}
+		return c.arg(s) + "::date", nil
+	}
+
+	if from, to, isRange := strings.Cut(tok.value, ".."); isRange {
+		fromArg, err := checkDate(from)
+		if err != nil {
+			return "", err
+		}
+		toArg, err := checkDate(to)
+		if err != nil {
+			return "", err
+		}
+		return column + " BETWEEN " + fromArg + " AND " + toArg, nil
+	}
+
+	op, date := "=", tok.value
+	for _, prefix := range []string{">=", "<=", ">", "<", "="} {
+		if strings.HasPrefix(date, prefix) {
+			op, date = prefix, date[len(prefix):]
+			break
+		}
+	}
+
+	dateArg, err := checkDate(date)
+	if err != nil {
+		return "", err
+	}
+	return column + op + dateArg, nil
+}
+
+/*
+- Compiles a due date filter, as well as dates it takes overdue, today, week (Monday to Sunday of this week) and none
+*/
+func (c *queryCompiler) compileDue(tok queryToken) (string, error) {
+	var cond string
+	switch strings.ToLower(tok.value) {
+	case "none":
+		return "note_due_date IS NULL", nil
+	case "overdue":
+		return "(note_due_date IS NOT NULL AND note_due_date < CURRENT_DATE AND NOT (" + terminalStateCondition + "))", nil
+	case "today":
+		cond = "note_due_date=CURRENT_DATE"
+	case "week":
+		cond = "note_due_date BETWEEN date_trunc('week', CURRENT_DATE)::date AND (date_trunc('week', CURRENT_DATE) + interval '6 days')::date"
+	default:
+		var err error
+		if cond, err = c.compileDate(tok, "note_due_date"); err != nil {
+			return "", err
+		}
+	}
+
+	// Notes without a due date never match, also when the filter is negated
+	return "(note_due_date IS NOT NULL AND " + cond + ")", nil
+}
+
+/*
+- Compiles a priority filter: high, >=medium, <high (or the numbers 0 to 3)
+*/
+func (c *queryCompiler) compilePriority(tok queryToken) (string, error) {
+	op, name := "=", tok.value
+	for _, prefix := range []string{">=", "<=", ">", "<", "="} {
+		if strings.HasPrefix(name, prefix) {
+			op, name = prefix, name[len(prefix):]
+			break
+		}
+	}
+
+	priority, ok := parsePriorityName(name)
+	if !ok {
+		return "", &SearchError{Pos: tok.pos, Msg: "unknown priority '" + name + "', expected none, low, medium or high"}
+	}
+	return "note_priority" + op + c.arg(priority), nil
+}
+
+/*
+- Reads a priority name such as 'high', or its number, case is ignored
+return: the priority and true, or false if there is no such priority
+*/
+func parsePriorityName(name string) (int, bool) {
+	if n, err := strconv.Atoi(name); err == nil {
+		return n, isValidNotePriority(n)
+	}
+
+	for priority := 0; priority < NotePriorityMax; priority++ {
+		if strings.EqualFold(notePriorityToString(priority), name) {
+			return priority, true
+		}
+	}
+	return 0, false
+}
+
+/*
+- Parses a search query and compiles it into a SQL condition
+  - terms are ANDed together, 'OR' matches either side and 'NOT' or '-' excludes
+  - (brackets) group terms
+  - "quoted words" must appear next to each other, a trailing '*' matches words starting with the term
+  - filters: owner:alice (or owner:me), assignee:bob (or assignee:me, assignee:none), flag:inprogress,
+    is:open (or is:closed), tag:infra, notebook:work (or notebook:none), created:>2024-01-01, completed:2024-01-01..2024-01-31,
+    due:week (or overdue, today, none or a date), priority:>=medium
+
+Args:
+
+	query: search as typed by the user
+	user: user searching, used by owner:me
+	wf: workflow, flag: is matched against its state names
+	args: arguments already used by the rest of the SQL statement, the condition's arguments follow them
+
+return: the condition (empty if the query is empty), all arguments,
+tsqueries used to rank and highlight results, or a *SearchError
+*/
+func compileSearchQuery(query string, user User, wf Workflow, args []any) (string, []any, []string, error) {
+	tokens, err := lexQuery(query)
+	if err != nil {
+		return "", args, nil, searchErrorInRunes(query, err)
+	}
+	if len(tokens) == 1 {
+		return "", args, nil, nil
+	}
+
+	c := &queryCompiler{tokens: tokens, user: user, workflow: wf, args: args}
+	cond, err := c.parseOr()
+	if err != nil {
+		return "", args, nil, searchErrorInRunes(query, err)
+	}
+	if tok := c.peek(); tok.kind != tokenEnd {
+		return "", args, nil, searchErrorInRunes(query, &SearchError{Pos: tok.pos, Msg: "unexpected ')'"})
+	}
+
+	return cond, c.args, c.rank, nil
+}
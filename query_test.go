@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var testWorkflow = Workflow{
+	States: []WorkflowState{
+		{Id: NoteFlagNote, Name: "Note"},
+		{Id: NoteFlagInProgress, Name: "In Progress"},
+		{Id: NoteFlagCompleted, Name: "Completed", Terminal: true},
+	},
+}
+
+func TestLexQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []queryToken
+	}{
+		{"", []queryToken{{kind: tokenEnd, pos: 0}}},
+		{"deploy", []queryToken{{kind: tokenWord, text: "deploy", pos: 0}, {kind: tokenEnd, pos: 6}}},
+		{`"on call" -x`, []queryToken{
+			{kind: tokenPhrase, text: "on call", pos: 0},
+			{kind: tokenNot, pos: 10},
+			{kind: tokenWord, text: "x", pos: 11},
+			{kind: tokenEnd, pos: 12},
+		}},
+		{"(a)", []queryToken{
+			{kind: tokenLParen, pos: 0},
+			{kind: tokenWord, text: "a", pos: 1},
+			{kind: tokenRParen, pos: 2},
+			{kind: tokenEnd, pos: 3},
+		}},
+		{`Tag:infra owner:"bob smith"`, []queryToken{
+			{kind: tokenField, text: "tag", value: "infra", pos: 0},
+			{kind: tokenField, text: "owner", value: "bob smith", pos: 10},
+			{kind: tokenEnd, pos: 27},
+		}},
+		// Punctuation on its own has nothing to search for, a lone '-' isn't a negation
+		{`- ... ""`, []queryToken{{kind: tokenEnd, pos: 8}}},
+		{":x", []queryToken{{kind: tokenWord, text: ":x", pos: 0}, {kind: tokenEnd, pos: 2}}},
+	}
+
+	for _, tt := range tests {
+		got, err := lexQuery(tt.query)
+		if err != nil {
+			t.Errorf("lexQuery(%q) returned %v", tt.query, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("lexQuery(%q) = %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestCompileSearchQuery(t *testing.T) {
+	const match = "note_search @@ to_tsquery('english', "
+	user := User{Id: 7}
+
+	tests := []struct {
+		name     string
+		query    string
+		prevArgs []any
+		wantCond string
+		wantArgs []any
+		wantRank []string
+	}{
+		{"empty", "  ", nil, "", nil, nil},
+		{"word", "deploy", nil, match + "$1)", []any{"deploy"}, []string{"deploy"}},
+		{"args follow the statement's", "deploy", []any{1, 2}, match + "$3)", []any{1, 2, "deploy"}, []string{"deploy"}},
+		{"implicit and", "a b", nil, "(" + match + "$1) AND " + match + "$2))", []any{"a", "b"}, []string{"a", "b"}},
+		{"explicit and", "a AND b", nil, "(" + match + "$1) AND " + match + "$2))", []any{"a", "b"}, []string{"a", "b"}},
+		{"and binds tighter than or", "a b OR c", nil,
+			"((" + match + "$1) AND " + match + "$2)) OR " + match + "$3))", []any{"a", "b", "c"}, []string{"a", "b", "c"}},
+		{"brackets", "a (b or c)", nil,
+			"(" + match + "$1) AND ((" + match + "$2) OR " + match + "$3))))", []any{"a", "b", "c"}, []string{"a", "b", "c"}},
+		{"negated terms aren't ranked", "a -b NOT c", nil,
+			"(" + match + "$1) AND NOT (" + match + "$2)) AND NOT (" + match + "$3)))", []any{"a", "b", "c"}, []string{"a"}},
+		{"double negation is ranked", "NOT -a", nil, "NOT (NOT (" + match + "$1)))", []any{"a"}, []string{"a"}},
+		{"phrase", `"on call"`, nil, match + "$1)", []any{"on <-> call"}, []string{"on <-> call"}},
+		{"prefix", "depl*", nil, match + "$1)", []any{"depl:*"}, []string{"depl:*"}},
+		{"tsquery syntax is stripped", "a&b|!c", nil, match + "$1)", []any{"a <-> b <-> c"}, []string{"a <-> b <-> c"}},
+		{"owner me", "owner:me", nil, "note_owner=$1", []any{int32(7)}, nil},
+		{"owner name", "owner:bob", nil, "note_owner IN (SELECT user_id FROM users WHERE username=$1)", []any{"bob"}, nil},
+		{"status", "status:inprogress", nil, "note_flag=$1", []any{NoteFlagInProgress}, nil},
+		{"tag is normalised", `tag:"On Call"`, nil,
+			"EXISTS (SELECT 1 FROM note_tags nt JOIN tags t ON t.tag_id=nt.tag_id WHERE nt.note_id=notes.note_id AND t.tag_name=$1)", []any{"on-call"}, nil},
+		{"date range", "created:2024-01-01..2024-01-31", nil, "note_date BETWEEN $1::date AND $2::date", []any{"2024-01-01", "2024-01-31"}, nil},
+		{"date comparison", "created:>=2024-01-01", nil, "note_date>=$1::date", []any{"2024-01-01"}, nil},
+		{"completed leaves out cancelled notes", "completed:2024-01-01", nil,
+			"(note_flag IN (" + strconv.Itoa(NoteFlagCompleted) + ") AND note_completion_date=$1::date)", []any{"2024-01-01"}, nil},
+		{"priority", "priority:>=medium", nil, "note_priority>=$1", []any{NotePriorityMedium}, nil},
+		{"due none", "due:none", nil, "note_due_date IS NULL", nil, nil},
+		{"sql in values is an argument", "owner:x';DROP", nil, "note_owner IN (SELECT user_id FROM users WHERE username=$1)", []any{"x';DROP"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cond, args, rank, err := compileSearchQuery(tt.query, user, testWorkflow, tt.prevArgs)
+			if err != nil {
+				t.Fatalf("compileSearchQuery(%q) returned %v", tt.query, err)
+			}
+			if cond != tt.wantCond {
+				t.Errorf("condition = %q, want %q", cond, tt.wantCond)
+			}
+			if len(args) != 0 || len(tt.wantArgs) != 0 {
+				if !reflect.DeepEqual(args, tt.wantArgs) {
+					t.Errorf("args = %#v, want %#v", args, tt.wantArgs)
+				}
+			}
+			if !reflect.DeepEqual(rank, tt.wantRank) {
+				t.Errorf("rank = %q, want %q", rank, tt.wantRank)
+			}
+		})
+	}
+}
+
+func TestCompileSearchQueryErrors(t *testing.T) {
+	tests := []struct {
+		query   string
+		wantPos int
+		wantMsg string
+	}{
+		{`"open`, 0, "missing closing quote"},
+		{"a (b", 2, "missing closing ')'"},
+		{"a)", 1, "unexpected ')'"},
+		{"a OR", 4, "OR needs a search term after it"},
+		{"OR a", 0, "OR needs a search term on both sides"},
+		{"AND a", 0, "AND needs a search term before it"},
+		{"a AND", 5, "AND needs a search term after it"},
+		{"()", 1, "expected a search term"},
+		{"tag:", 0, "missing value for 'tag:'"},
+		{"colour:red", 0, "unknown filter 'colour:'"},
+		{"is:maybe", 0, "unknown value 'maybe' for 'is:'"},
+		{"created:yesterday", 0, "invalid date 'yesterday'"},
+		{"status:done", 0, "unknown status 'done'"},
+		{"priority:urgent", 0, "unknown priority 'urgent'"},
+		// Positions count characters, not bytes
+		{"café (thé", 5, "missing closing ')'"},
+		{`日本 "語`, 3, "missing closing quote"},
+	}
+
+	for _, tt := range tests {
+		_, args, _, err := compileSearchQuery(tt.query, User{Id: 1}, testWorkflow, []any{"kept"})
+
+		var searchErr *SearchError
+		if !errors.As(err, &searchErr) {
+			t.Errorf("compileSearchQuery(%q) returned %v, want a *SearchError", tt.query, err)
+			continue
+		}
+		if searchErr.Pos != tt.wantPos || !strings.HasPrefix(searchErr.Msg, tt.wantMsg) {
+			t.Errorf("compileSearchQuery(%q) error = %d %q, want %d %q", tt.query, searchErr.Pos, searchErr.Msg, tt.wantPos, tt.wantMsg)
+		}
+		if len(args) != 1 {
+			t.Errorf("compileSearchQuery(%q) changed the statement's args to %v", tt.query, args)
+		}
+	}
+}
+
+func TestSearchErrorMessage(t *testing.T) {
+	err := &SearchError{Pos: 4, Msg: "missing closing quote"}
+	if got, want := err.Error(), "missing closing quote (at character 5)"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"database/sql"
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+/* - A revision and what changed since the revision before it - */
+type RevisionView struct {
+	Revision    NoteRevision
+	NameChanged bool
+	PrevName    string
+	Diff        []DiffLine
+}
+
+type HistoryData struct {
+	CurrentUser User
+	Note        Note
+	CanEdit     bool
+	Revisions   []RevisionView
+	Assignments []NoteAssignment
+	Comments    []NoteComment
+	CanComment  bool
+	Links       []NoteLink
+	CanShare    bool
+	FlashMsg    string
+}
+
+/*
+- Records a snapshot of a note
+Args:
+
+	tx: transaction the note is being written in
+	note: note as it has been saved
+	author: user_id of the user that made the change
+
+return: nil or an error
+*/
+func insertNoteRevision(tx *sql.Tx, note Note, author int32) error {
+	_, err := tx.Exec("INSERT INTO note_revisions(note_id, revision_author, revision_date, note_share, note_visibility, note_name, note_completion_date, note_flag, note_content) VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+		note.Id, author, time.Now(), note.Share, note.Visibility, note.Name, note.CompletionDate, note.Flag, note.Content)
+	return err
+}
+
+/*
+- Fetches every revision of a note, newest first
+Args:
+
+	noteId: note_id of the note
+
+return: list of revisions or an error
+*/
+func (a *App) fetchNoteRevisions(noteId int32) ([]NoteRevision, error) {
+	rows, err := a.db.Query("SELECT revision_id, note_id, revision_author, revision_date, note_share, note_visibility, note_name, note_completion_date, note_flag, note_content FROM note_revisions WHERE note_id=$1 ORDER BY revision_id DESC",
+		noteId)
+	if err != nil {
+		return make([]NoteRevision, 0), err
+	}
+	defer rows.Close()
+
+	revisions := []NoteRevision{}
+	for rows.Next() {
+		var rev NoteRevision
+		if e := rows.Scan(&rev.Id, &rev.NoteId, &rev.Author, &rev.Date, &rev.Share, &rev.Visibility, &rev.Name, &rev.CompletionDate, &rev.Flag, &rev.Content); e != nil {
+			return make([]NoteRevision, 0), e
+		}
+		revisions = append(revisions, rev)
+	}
+
+	return revisions, nil
+}
+
+/*
+- Fetches a single revision of a note
+Args:
+
+	noteId: note_id of the note
+	revisionId: revision_id of the revision
+
+return: the revision or an error (sql.ErrNoRows if the note has no such revision)
+*/
+func (a *App) fetchNoteRevision(noteId, revisionId int32) (NoteRevision, error) {
+	var rev NoteRevision
+	err := a.db.QueryRow("SELECT revision_id, note_id, revision_author, revision_date, note_share, note_visibility, note_name, note_completion_date, note_flag, note_content FROM note_revisions WHERE note_id=$1 AND revision_id=$2",
+		noteId, revisionId).Scan(&rev.Id, &rev.NoteId, &rev.Author, &rev.Date, &rev.Share, &rev.Visibility, &rev.Name, &rev.CompletionDate, &rev.Flag, &rev.Content)
+	if err != nil {
+		return NoteRevision{}, err
+	}
+	return rev, nil
+}
+
+/*
+- Pairs every revision with the diff from the revision before it
+Args:
+
+	revisions: revisions newest first
+
+return: revisions with their diffs, the oldest is diffed against an empty note
+*/
+func buildRevisionViews(revisions []NoteRevision) []RevisionView {
+	views := make([]RevisionView, 0, len(revisions))
+	for i, rev := range revisions {
+		view := RevisionView{Revision: rev}
+
+		if i+1 < len(revisions) {
+			prev := revisions[i+1]
+			view.Diff = diffLines(prev.Content, rev.Content)
+			view.NameChanged = prev.Name != rev.Name
+			view.PrevName = prev.Name
+		} else {
+			view.Diff = diffLines("", rev.Content)
+		}
+
+		views = append(views, view)
+	}
+	return views
+}
+
+func (a *App) noteHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAuthenticated(w, r) {
+		return
+	}
+
+	user, err := a.fetchCurrentUser(r)
+	checkInternalServerError(err, w)
+
+	noteId, err := getIdFromPath(r)
+	if err != nil {
+		http.Error(w, "invalid note id", http.StatusBadRequest)
+		return
+	}
+
+	note, err := a.fetchNote(noteId)
+	switch {
+	case err == sql.ErrNoRows:
+		http.NotFound(w, r)
+		return
+	case err != nil:
+		checkInternalServerError(err, w)
+		return
+	case !canViewNote(user, note):
+		forbidden(w)
+		return
+	}
+
+	revisions, err := a.fetchNoteRevisions(note.Id)
+	checkInternalServerError(err, w)
+
+	wf, err := a.fetchWorkflow()
+	checkInternalServerError(err, w)
+
+	assignments, err := a.fetchNoteAssignments(note.Id)
+	checkInternalServerError(err, w)
+
+	comments, err := a.fetchNoteComments(note.Id)
+	checkInternalServerError(err, w)
+
+	// Public links are only listed to the people who can revoke them
+	links := []NoteLink{}
+	if canShareNote(user, note) {
+		links, err = a.fetchNoteLinks(note.Id)
+		checkInternalServerError(err, w)
+	}
+
+	tmplData := HistoryData{
+		CurrentUser: user,
+		Note:        note,
+		CanEdit:     canEditNote(user, note),
+		Revisions:   buildRevisionViews(revisions),
+		Assignments: assignments,
+		Comments:    comments,
+		CanComment:  canCommentNote(user, note),
+		Links:       links,
+		CanShare:    canShareNote(user, note),
+		FlashMsg:    popFlash(w, r, FlashDashboard),
+	}
+
+	executeTemplate(w, "history.html", "web/history.html",
+		template.FuncMap{
+			"getUserName": func(id int32) string {
+				name, err := a.fetchUserName(id)
+				checkInternalServerError(err, w)
+				return name
+			},
+			"longDate": func(date time.Time) string {
+				return date.Format("02/01/2006 15:04")
+			},
+			"optionalDate": func(date sql.NullTime, unset string) string {
+				if !date.Valid {
+					return unset
+				}
+				return date.Time.Format("02/01/2006 15:04")
+			},
+			"noteFlagToString": wf.stateName,
+			"diffClass": func(kind int) string {
+				return []string{"diff-same", "diff-added", "diff-removed"}[kind]
+			},
+			"diffPrefix": func(kind int) string {
+				return []string{" ", "+", "-"}[kind]
+			},
+		},
+		tmplData)
+}
+
+func (a *App) restoreRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAuthenticated(w, r) {
+		return
+	}
+
+	user, err := a.fetchCurrentUser(r)
+	checkInternalServerError(err, w)
+
+	noteId, err := getIdFromPath(r)
+	if err != nil {
+		http.Error(w, "invalid note id", http.StatusBadRequest)
+		return
+	}
+
+	revisionId, err := strconv.ParseInt(mux.Vars(r)["rev"], 10, 32)
+	if err != nil {
+		http.Error(w, "invalid revision id", http.StatusBadRequest)
+		return
+	}
+
+	note, err := a.fetchNote(noteId)
+	switch {
+	case err == sql.ErrNoRows:
+		http.NotFound(w, r)
+		return
+	case err != nil:
+		checkInternalServerError(err, w)
+		return
+	case !canEditNote(user, note):
+		forbidden(w)
+		return
+	}
+
+	rev, err := a.fetchNoteRevision(note.Id, int32(revisionId))
+	switch {
+	case err == sql.ErrNoRows:
+		http.NotFound(w, r)
+		return
+	case err != nil:
+		checkInternalServerError(err, w)
+		return
+	}
+
+	wf, err := a.fetchWorkflow()
+	checkInternalServerError(err, w)
+
+	// Restoring is just another edit, so it gets its own revision
+	if msg := wf.moveNote(&note, rev.Flag); msg != "" {
+		setFlash(w, FlashDashboard, "Couldn't restore the revision: "+msg)
+		http.Redirect(w, r, "/notes/"+strconv.Itoa(int(note.Id))+"/history", http.StatusSeeOther)
+		return
+	}
+	note.Name = rev.Name
+	note.Content = rev.Content
+	dropped := false
+	if canShareNote(user, note) {
+		targets, err := a.fetchShareTargets(user)
+		if err != nil {
+			checkInternalServerError(err, w)
+			return
+		}
+
+		// Users keep the role they have now, users that weren't shared with before are viewers.
+		// Users who now only accept notes from their colleagues aren't shared with again
+		note.Share, dropped = keepShareTargets(rev.Share, note.Share, targets)
+		note.Visibility = rev.Visibility
+		note = keepCoOwner(normaliseVisibility(note), user)
+	}
+
+	err = a.updateNote(note, user)
+	checkInternalServerError(err, w)
+
+	msg := "Restored '" + note.Name + "' to the revision from " + rev.Date.Format("02/01/2006 15:04")
+	if dropped {
+		msg += ", it wasn't shared with some people again, they only accept notes from their colleagues"
+	}
+	setFlash(w, FlashDashboard, msg)
+	http.Redirect(w, r, "/notes/"+strconv.Itoa(int(note.Id))+"/history", http.StatusSeeOther)
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+/* - A saved search and how many notes it currently matches - */
+type SavedSearchView struct {
+	Search SavedSearch
+	Count  int
+	Error  string // set if the saved query no longer parses, Count is then 0
+}
+
+/* - Saved search as it is sent by the api - */
+type apiSavedSearch struct {
+	Id     int32   `json:"id"`
+	Owner  int32   `json:"owner"`
+	Name   string  `json:"name"`
+	Query  string  `json:"query"`
+	Pinned bool    `json:"pinned"`
+	Share  []int32 `json:"share"`
+	Count  int     `json:"count"`
+	Error  string  `json:"error,omitempty"`
+}
+
+/* - Body of a save search request - */
+type apiSavedSearchRequest struct {
+	Name   string  `json:"name"`
+	Query  string  `json:"query"`
+	Pinned bool    `json:"pinned"`
+	Share  []int32 `json:"share"`
+}
+
+/*
+- Gets the dashboard url of a saved search
+*/
+func (s SavedSearch) Url() string {
+	if s.Query == "" {
+		return "/dashboard"
+	}
+	return "/dashboard?" + s.Query
+}
+
+/*
+- Gets the views shown on every dashboard next to the pinned searches, they can't be edited or deleted
+*/
+func dashboardViews() []SavedSearch {
+	view := func(name, query string) SavedSearch {
+		return SavedSearch{Name: name, Query: url.Values{"q": {query}, "sort": {"due"}}.Encode()}
+	}
+
+	return []SavedSearch{
+		view("Overdue", "due:overdue"),
+		view("Delegated to me", "assignee:me is:open"),
+		view("Mine due this week", "(assignee:me OR (owner:me assignee:none)) due:week is:open"),
+	}
+}
+
+/*
+- Puts a query string into the form it is saved in, unknown and empty filters are dropped
+Args:
+
+	query: query string with or without the leading '?'
+
+return: the query string without the leading '?'
+*/
+func normaliseSavedQuery(query string) string {
+	values, _ := url.ParseQuery(strings.TrimPrefix(query, "?"))
+	return strings.TrimPrefix(parseSearchQuery(values).Encode(), "?")
+}
+
+/*
+- Keeps only the ids of a user's colleagues, saved searches can only be shared with colleagues
+Args:
+
+	ids: user ids to share with
+	settings: settings of the user sharing
+
+return: list of colleague ids
+*/
+func filterColleagueIds(ids []int32, settings UserSettings) []int32 {
+	colleagues := []int32{}
+	for _, id := range ids {
+		if slices.Contains(settings.Colleagues, id) && !slices.Contains(colleagues, id) {
+			colleagues = append(colleagues, id)
+		}
+	}
+	return colleagues
+}
+
+/*
+- Fetches the searches saved by a user and those shared with them
+Args:
+
+	user: user the searches are fetched for
+
+return: list of saved searches, the user's own first, or an error
+*/
+func (a *App) fetchSavedSearches(user User) ([]SavedSearch, error) {
+	rows, err := a.db.Query("SELECT search_id, user_id, search_name, search_query, search_pinned, search_share FROM saved_searches WHERE user_id=$1 OR $1=ANY(search_share) ORDER BY user_id!=$1, search_name",
+		user.Id)
+	if err != nil {
+		return make([]SavedSearch, 0), err
+	}
+	defer rows.Close()
+
+	searches := []SavedSearch{}
+	for rows.Next() {
+		var s SavedSearch
+		if e := rows.Scan(&s.Id, &s.Owner, &s.Name, &s.Query, &s.Pinned, &s.Share); e != nil {
+			return make([]SavedSearch, 0), e
+		}
+		searches = append(searches, s)
+	}
+
+	return searches, nil
+}
+
+/*
+- Saves a search
+Args:
+
+	search: search to save (search.Id is ignored)
+
+return: the saved search or an error
+*/
+func (a *App) insertSavedSearch(search SavedSearch) (SavedSearch, error) {
+	search.Name = truncateRunes(search.Name, SearchNameMaxLength)
+	if search.Share == nil {
+		search.Share = []int32{}
+	}
+
+	err := a.db.QueryRow("INSERT INTO saved_searches(user_id, search_name, search_query, search_pinned, search_share) VALUES($1, $2, $3, $4, $5) RETURNING search_id",
+		search.Owner, search.Name, search.Query, search.Pinned, search.Share).Scan(&search.Id)
+	return search, err
+}
+
+/*
+- Deletes one of a user's saved searches
+Args:
+
+	user: owner of the search
+	id: search_id
+
+return: false if the user has no such search, or an error
+*/
+func (a *App) deleteSavedSearch(user User, id int32) (bool, error) {
+	res, err := a.db.Exec("DELETE FROM saved_searches WHERE search_id=$1 AND user_id=$2", id, user.Id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+/*
+- Counts how many notes each saved search matches for a user
+Args:
+
+	user: user the searches are run as
+	searches: saved searches
+	wf: workflow the flag filters are resolved against
+
+return: the searches with their counts or an error
+*/
+func (a *App) countSavedSearches(user User, searches []SavedSearch, wf Workflow) ([]SavedSearchView, error) {
+	views := make([]SavedSearchView, 0, len(searches))
+	for _, s := range searches {
+		values, _ := url.ParseQuery(s.Query)
+		count, err := a.countNotes(user, parseSearchQuery(values), wf)
+
+		view := SavedSearchView{Search: s, Count: count}
+		var searchErr *SearchError
+		if errors.As(err, &searchErr) {
+			view.Error = searchErr.Error()
+		} else if err != nil {
+			return make([]SavedSearchView, 0), err
+		}
+
+		views = append(views, view)
+	}
+	return views, nil
+}
+
+func toApiSavedSearch(view SavedSearchView) apiSavedSearch {
+	share := []int32(view.Search.Share)
+	if share == nil {
+		share = []int32{}
+	}
+	return apiSavedSearch{
+		Id:     view.Search.Id,
+		Owner:  view.Search.Owner,
+		Name:   view.Search.Name,
+		Query:  view.Search.Query,
+		Pinned: view.Search.Pinned,
+		Share:  share,
+		Count:  view.Count,
+		Error:  view.Error,
+	}
+}
+
+func (a *App) saveSearchHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAuthenticated(w, r) {
+		return
+	}
+
+	user, err := a.fetchCurrentUser(r)
+	checkInternalServerError(err, w)
+
+	settings, err := a.fetchUserSettings(user)
+	checkInternalServerError(err, w)
+
+	query := normaliseSavedQuery(r.FormValue("search-query"))
+	name := r.FormValue("search-name")
+	if name == "" {
+		setFlash(w, FlashDashboard, "A saved search needs a name")
+		http.Redirect(w, r, "/dashboard?"+query, http.StatusSeeOther)
+		return
+	}
+
+	r.ParseForm()
+	var share []int32
+	for _, v := range r.Form["search-share"] {
+		if id, err := strconv.Atoi(v); err == nil {
+			share = append(share, int32(id))
+		}
+	}
+
+	search, err := a.insertSavedSearch(SavedSearch{
+		Owner:  user.Id,
+		Name:   name,
+		Query:  query,
+		Pinned: r.FormValue("search-pinned") != "",
+		Share:  filterColleagueIds(share, settings),
+	})
+	checkInternalServerError(err, w)
+
+	http.Redirect(w, r, search.Url(), http.StatusSeeOther)
+}
+
+func (a *App) pinSearchHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAuthenticated(w, r) {
+		return
+	}
+
+	user, err := a.fetchCurrentUser(r)
+	checkInternalServerError(err, w)
+
+	id, err := getIdFromPath(r)
+	if err != nil {
+		http.Error(w, "invalid search id", http.StatusBadRequest)
+		return
+	}
+
+	_, err = a.db.Exec("UPDATE saved_searches SET search_pinned=NOT search_pinned WHERE search_id=$1 AND user_id=$2", id, user.Id)
+	checkInternalServerError(err, w)
+
+	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+}
+
+func (a *App) deleteSearchHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAuthenticated(w, r) {
+		return
+	}
+
+	user, err := a.fetchCurrentUser(r)
+	checkInternalServerError(err, w)
+
+	id, err := getIdFromPath(r)
+	if err != nil {
+		http.Error(w, "invalid search id", http.StatusBadRequest)
+		return
+	}
+
+	_, err = a.deleteSavedSearch(user, id)
+	checkInternalServerError(err, w)
+
+	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+}
+
+// GET /api/v1/searches
+func (a *App) apiListSavedSearchesHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.apiCurrentUser(w, r)
+	if !ok {
+		return
+	}
+
+	searches, err := a.fetchSavedSearches(user)
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	wf, err := a.fetchWorkflow()
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	views, err := a.countSavedSearches(user, searches, wf)
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	apiSearches := make([]apiSavedSearch, 0, len(views))
+	for _, view := range views {
+		apiSearches = append(apiSearches, toApiSavedSearch(view))
+	}
+
+	writeJSON(w, http.StatusOK, apiSearches)
+}
+
+// POST /api/v1/searches
+func (a *App) apiCreateSavedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.apiCurrentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req apiSavedSearchRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	if req.Name == "" {
+		writeJSONError(w, http.StatusBadRequest, "a saved search needs a name")
+		return
+	}
+
+	settings, err := a.fetchUserSettings(user)
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	search, err := a.insertSavedSearch(SavedSearch{
+		Owner:  user.Id,
+		Name:   req.Name,
+		Query:  normaliseSavedQuery(req.Query),
+		Pinned: req.Pinned,
+		Share:  filterColleagueIds(req.Share, settings),
+	})
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	wf, err := a.fetchWorkflow()
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	views, err := a.countSavedSearches(user, []SavedSearch{search}, wf)
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, toApiSavedSearch(views[0]))
+}
+
+// DELETE /api/v1/searches/{id}
+func (a *App) apiDeleteSavedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.apiCurrentUser(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := getIdFromPath(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid search id")
+		return
+	}
+
+	found, err := a.deleteSavedSearch(user, id)
+	switch {
+	case err != nil:
+		writeJSONInternalError(w, err)
+	case !found:
+		writeJSONError(w, http.StatusNotFound, "saved search not found")
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
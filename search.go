@@ -0,0 +1,247 @@
+package main
+
+import (
+	"html"
+	"html/template"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Options for ts_headline, the highlights are escaped before they reach a template
+const (
+	headlineNameOptions    = "StartSel=<mark>, StopSel=</mark>, HighlightAll=true"
+	headlineContentOptions = "StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=30, MinWords=10, FragmentDelimiter=\" ... \""
+)
+
+/* - Name and content of a search result with the matching words highlighted - */
+type NoteHighlight struct {
+	Name    template.HTML
+	Content template.HTML
+}
+
+/*
+- Escapes a ts_headline result, keeping only the <mark> tags added by PostgreSQL
+*/
+func escapeHeadline(headline string) template.HTML {
+	escaped := html.EscapeString(headline)
+	escaped = strings.ReplaceAll(escaped, "&lt;mark&gt;", "<mark>")
+	escaped = strings.ReplaceAll(escaped, "&lt;/mark&gt;", "</mark>")
+	return template.HTML(escaped)
+}
+
+/* - A page of search results - */
+type NotePage struct {
+	Notes      []Note
+	Highlights map[int32]*NoteHighlight // by note_id, only for a keyword search
+	Next       string                   // cursor for the next page, empty on the last page
+	Prev       string                   // cursor for the previous page, empty on the first page
+}
+
+/*
+- Builds the SQL conditions for a search, only notes the user can see that aren't in the trash match
+Args:
+
+	user: user searching
+	search: filters to apply
+	  - Keyword: search query (see compileSearchQuery)
+	  - User: note_owner is the user
+	  - Date: note_date or, for completed notes, note_completion_date is the date
+	  - Flag: note_flag is the flag
+	  - Tag: the note has the tag
+	  - Notebook: note_notebook is the notebook (0 for notes not in a notebook)
+	  - Group: the note is shared with the group
+	wf: workflow, used to look up states by name and to find the states that complete a note
+
+return: conditions to AND together, their arguments, the tsquery to rank results by (empty if there is nothing to rank)
+or an error (*SearchError if the query can't be parsed)
+*/
+func searchConditions(user User, search SearchQuery, wf Workflow) ([]string, []any, string, error) {
+	args := []any{user.Id}
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	conditions := []string{"note_deleted IS NULL", noteVisibleCondition("$1")}
+
+	if search.User != -1 {
+		conditions = append(conditions, "note_owner="+arg(search.User))
+	}
+	if search.Date != "" {
+		date := arg(search.Date)
+		conditions = append(conditions, "(note_date="+date+"::date OR ("+wf.completedCondition()+" AND note_completion_date="+date+"::date))")
+	}
+	if search.Flag != -1 {
+		conditions = append(conditions, "note_flag="+arg(search.Flag))
+	}
+	if search.Tag != "" {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM note_tags nt JOIN tags t ON t.tag_id=nt.tag_id WHERE nt.note_id=notes.note_id AND t.tag_name="+arg(search.Tag)+")")
+	}
+	switch {
+	case search.Notebook == 0:
+		conditions = append(conditions, "note_notebook IS NULL")
+	case search.Notebook != -1:
+		conditions = append(conditions, "note_notebook="+arg(search.Notebook))
+	}
+	if search.Group != -1 {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM note_group_shares WHERE note_id=notes.note_id AND group_id="+arg(search.Group)+")")
+	}
+
+	cond, args, rank, err := compileSearchQuery(search.Keyword, user, wf, args)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if cond != "" {
+		conditions = append(conditions, cond)
+	}
+
+	// Results are ranked against every search term that isn't excluded
+	tsQuery := ""
+	if len(rank) > 0 {
+		tsQuery = "(" + strings.Join(rank, ") | (") + ")"
+	}
+
+	return conditions, args, tsQuery, nil
+}
+
+/*
+- Counts the notes matching a search
+Args:
+
+	user: user searching
+	search: filters to apply (see searchConditions)
+	wf: workflow the flag filters are resolved against
+
+return: number of notes or an error (*SearchError if the query can't be parsed)
+*/
+func (a *App) countNotes(user User, search SearchQuery, wf Workflow) (int, error) {
+	conditions, args, _, err := searchConditions(user, search, wf)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	err = a.db.QueryRow("SELECT COUNT(*) FROM notes WHERE "+strings.Join(conditions, " AND "), args...).Scan(&count)
+	return count, err
+}
+
+/*
+- Searches the notes in PostgreSQL and returns a page of the results, pages are found with a cursor (keyset pagination)
+Args:
+
+	user: user searching, only notes they can see are returned
+	search: filters to apply (see searchConditions)
+	page: sort order, page size and cursor
+	wf: workflow the flag filters are resolved against
+
+return: the page of notes or an error (*SearchError if the query can't be parsed)
+*/
+func (a *App) searchNotes(user User, search SearchQuery, page PageQuery, wf Workflow) (NotePage, error) {
+	conditions, args, tsQuery, err := searchConditions(user, search, wf)
+	if err != nil {
+		return NotePage{}, err
+	}
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	sort, desc := page.resolveSort(tsQuery != "")
+	column := sortColumns[sort]
+
+	// Going back a page walks the sort order backwards from the first note of the page after it
+	backwards := false
+	cursor := page.After
+	if page.Before != "" {
+		backwards, cursor = true, page.Before
+	}
+
+	// An invalid cursor starts from the first page
+	value, id, hasCursor := decodeCursor(cursor, sort)
+	if !hasCursor {
+		backwards, cursor = false, ""
+	}
+
+	direction, compare := "ASC", ">"
+	if desc != backwards {
+		direction, compare = "DESC", "<"
+	}
+	if hasCursor {
+		conditions = append(conditions, "("+column.expr+", note_id) "+compare+" ("+arg(value)+"::"+column.cast+", "+arg(id)+")")
+	}
+
+	columns := noteColumns + ", (" + column.expr + ")::text"
+	from := "notes"
+	if tsQuery != "" {
+		columns += ", ts_headline('english', note_name, query, " + arg(headlineNameOptions) + ")" +
+			", ts_headline('english', note_content, query, " + arg(headlineContentOptions) + ")"
+		from = "notes, to_tsquery('english', " + arg(tsQuery) + ") query"
+	}
+
+	// One extra row is fetched to find out if there is another page
+	query := "SELECT " + columns + " FROM " + from + " WHERE " + strings.Join(conditions, " AND ") +
+		" ORDER BY " + column.expr + " " + direction + ", note_id " + direction +
+		" LIMIT " + arg(page.Limit+1)
+
+	rows, err := a.db.Query(query, args...)
+	if err != nil {
+		return NotePage{}, err
+	}
+	defer rows.Close()
+
+	result := NotePage{Notes: []Note{}, Highlights: map[int32]*NoteHighlight{}}
+	cursors := []string{}
+	for rows.Next() {
+		var note Note
+		var sortValue, name, content string
+		if tsQuery == "" {
+			note, err = scanNote(rows, &sortValue)
+		} else {
+			note, err = scanNote(rows, &sortValue, &name, &content)
+		}
+		if err != nil {
+			return NotePage{}, err
+		}
+
+		result.Notes = append(result.Notes, note)
+		cursors = append(cursors, encodeCursor(sort, sortValue, note.Id))
+		if tsQuery != "" {
+			result.Highlights[note.Id] = &NoteHighlight{Name: escapeHeadline(name), Content: escapeHeadline(content)}
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return NotePage{}, err
+	}
+
+	hasMore := len(result.Notes) > page.Limit
+	if hasMore {
+		result.Notes = result.Notes[:page.Limit]
+		cursors = cursors[:page.Limit]
+	}
+	if backwards {
+		slices.Reverse(result.Notes)
+		slices.Reverse(cursors)
+	}
+
+	if len(result.Notes) > 0 {
+		first, last := cursors[0], cursors[len(cursors)-1]
+		switch {
+		case backwards:
+			// There is always a next page as that is where we came from
+			result.Next = last
+			if hasMore {
+				result.Prev = first
+			}
+		default:
+			if hasMore {
+				result.Next = last
+			}
+			if cursor != "" {
+				result.Prev = first
+			}
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/gob"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/icza/session"
+)
+
+/*
+  - Session loaded from the 'sessions' table.
+  - Field names match the icza session implementation so sessions created with
+    session.NewSessionOptions can be gob encoded and decoded into this type.
+*/
+type pgSession struct {
+	IDF       string
+	CreatedF  time.Time
+	AccessedF time.Time
+	CAttrsF   map[string]interface{}
+	AttrsF    map[string]interface{}
+	TimeoutF  time.Duration
+
+	mux   *sync.RWMutex
+	store *pgStore
+}
+
+func (s *pgSession) ID() string {
+	return s.IDF
+}
+
+func (s *pgSession) New() bool {
+	return s.CreatedF == s.AccessedF
+}
+
+func (s *pgSession) CAttr(name string) interface{} {
+	return s.CAttrsF[name]
+}
+
+func (s *pgSession) Attr(name string) interface{} {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	return s.AttrsF[name]
+}
+
+// Changes are written straight to the database
+func (s *pgSession) SetAttr(name string, value interface{}) {
+	s.mux.Lock()
+	if value == nil {
+		delete(s.AttrsF, name)
+	} else {
+		s.AttrsF[name] = value
+	}
+	s.mux.Unlock()
+
+	s.store.save(s)
+}
+
+func (s *pgSession) Attrs() map[string]interface{} {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	m := make(map[string]interface{}, len(s.AttrsF))
+	for k, v := range s.AttrsF {
+		m[k] = v
+	}
+	return m
+}
+
+func (s *pgSession) Created() time.Time {
+	return s.CreatedF
+}
+
+func (s *pgSession) Accessed() time.Time {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	return s.AccessedF
+}
+
+func (s *pgSession) Timeout() time.Duration {
+	return s.TimeoutF
+}
+
+func (s *pgSession) Mutex() *sync.RWMutex {
+	return s.mux
+}
+
+func (s *pgSession) Access() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.AccessedF = time.Now()
+}
+
+/*
+  - Session store backed by the 'sessions' table so sessions survive a restart.
+  - A session expires SessionMaxLifetime after it was created, or when it hasn't
+    been used for its idle timeout (session.Timeout()).
+*/
+type pgStore struct {
+	db           *sql.DB
+	closeSweeper chan struct{}
+}
+
+/*
+- Creates a PostgreSQL session store and starts its sweeper
+Args:
+
+	db: database containing the 'sessions' table
+
+return: the session store
+*/
+func newPgStore(db *sql.DB) *pgStore {
+	s := &pgStore{
+		db:           db,
+		closeSweeper: make(chan struct{}),
+	}
+
+	go s.sweeper(SessionSweepInterval)
+
+	return s
+}
+
+/*
+- Periodically deletes expired sessions until the store is closed
+*/
+func (s *pgStore) sweeper(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.closeSweeper:
+			return
+		case <-ticker.C:
+			res, err := s.db.Exec("DELETE FROM sessions WHERE session_expires < NOW() OR session_accessed + session_idle_timeout * INTERVAL '1 second' < NOW()")
+			if err != nil {
+				log.Print(err)
+				continue
+			}
+			if n, _ := res.RowsAffected(); n > 0 {
+				log.Printf("Removed %d expired sessions", n)
+			}
+		}
+	}
+}
+
+func encodeSession(sess session.Session) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(sess)
+	return buf.Bytes(), err
+}
+
+// Get is to implement session.Store.Get()
+func (s *pgStore) Get(id string) session.Session {
+	var data []byte
+	err := s.db.QueryRow("UPDATE sessions SET session_accessed=NOW() WHERE session_id=$1 AND session_expires > NOW() AND session_accessed + session_idle_timeout * INTERVAL '1 second' > NOW() RETURNING session_data",
+		id).Scan(&data)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Print(err)
+		}
+		return nil
+	}
+
+	sess := &pgSession{mux: &sync.RWMutex{}, store: s}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(sess); err != nil {
+		log.Print(err)
+		return nil
+	}
+	if sess.AttrsF == nil {
+		sess.AttrsF = make(map[string]interface{})
+	}
+
+	sess.Access()
+	return sess
+}
+
+// Add is to implement session.Store.Add()
+func (s *pgStore) Add(sess session.Session) {
+	data, err := encodeSession(sess)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	userId, _ := sess.CAttr("userid").(int32)
+
+	_, err = s.db.Exec("INSERT INTO sessions(session_id, user_id, session_data, session_created, session_accessed, session_expires, session_idle_timeout) VALUES($1, $2, $3, $4, $5, $6, $7)",
+		sess.ID(), userId, data, sess.Created(), sess.Accessed(), sess.Created().Add(SessionMaxLifetime), int(sess.Timeout().Seconds()))
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+// Remove is to implement session.Store.Remove()
+func (s *pgStore) Remove(sess session.Session) {
+	if _, err := s.db.Exec("DELETE FROM sessions WHERE session_id=$1", sess.ID()); err != nil {
+		log.Print(err)
+	}
+}
+
+// Close is to implement session.Store.Close()
+func (s *pgStore) Close() {
+	close(s.closeSweeper)
+}
+
+/*
+- Writes a session's attributes back to the database
+*/
+func (s *pgStore) save(sess *pgSession) {
+	sess.mux.RLock()
+	data, err := encodeSession(sess)
+	sess.mux.RUnlock()
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	if _, err := s.db.Exec("UPDATE sessions SET session_data=$1 WHERE session_id=$2", data, sess.IDF); err != nil {
+		log.Print(err)
+	}
+}
+
+/*
+- Removes every session of a user except one
+Args:
+
+	userId: user to log out
+	keepId: session to keep (the current one)
+
+return: number of sessions removed or an error
+*/
+func (s *pgStore) removeOtherSessions(userId int32, keepId string) (int64, error) {
+	res, err := s.db.Exec("DELETE FROM sessions WHERE user_id=$1 AND session_id!=$2", userId, keepId)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -1,4 +1,22 @@
+DROP TABLE IF EXISTS "schema_migrations";
+DROP TABLE IF EXISTS "note_links";
+DROP TABLE IF EXISTS "colleague_requests";
+DROP TABLE IF EXISTS "note_group_shares";
+DROP TABLE IF EXISTS "group_members";
+DROP TABLE IF EXISTS "user_groups";
+DROP TABLE IF EXISTS "note_comments";
+DROP TABLE IF EXISTS "note_shares";
+DROP TABLE IF EXISTS "workflow_transitions";
+DROP TABLE IF EXISTS "workflow_states";
+DROP TABLE IF EXISTS "note_assignments";
+DROP TABLE IF EXISTS "saved_searches";
+DROP TABLE IF EXISTS "note_tags";
+DROP TABLE IF EXISTS "tags";
+DROP TABLE IF EXISTS "note_revisions";
+DROP TABLE IF EXISTS "sessions";
+DROP TABLE IF EXISTS "api_tokens";
 DROP TABLE IF EXISTS "notes";
+DROP TABLE IF EXISTS "notebooks";
 DROP TABLE IF EXISTS "user_settings";
 DROP TABLE IF EXISTS "users";
 
@@ -28,7 +46,7 @@ VALUES(1);
 CREATE TABLE "notes" (
     note_id SERIAL PRIMARY KEY NOT NULL,
     note_owner INTEGER NOT NULL,
-    note_share INTEGER[], -- Comma seperated list of usernames, If set to global it is visible to all users
+    note_share INTEGER[], -- Users the note is shared with, who can see it is set by note_visibility (see migrations)
     note_name VARCHAR(255) NOT NULL,
     note_date DATE NOT NULL,
     note_completion_date DATE NOT NULL,
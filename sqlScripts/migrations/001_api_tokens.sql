@@ -0,0 +1,14 @@
+-- Personal api tokens, only a sha256 hash of the token is stored
+CREATE TABLE IF NOT EXISTS "api_tokens" (
+    token_id SERIAL PRIMARY KEY NOT NULL,
+    user_id INTEGER NOT NULL,
+    token_name VARCHAR(255) NOT NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    token_scope INTEGER NOT NULL,
+    token_created TIMESTAMP NOT NULL DEFAULT NOW(),
+    token_last_used TIMESTAMP,
+    CONSTRAINT fk_token_user
+        FOREIGN KEY(user_id)
+            REFERENCES users(user_id)
+                ON DELETE CASCADE
+);
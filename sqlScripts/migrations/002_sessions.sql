@@ -0,0 +1,16 @@
+-- Login sessions, stored here so they survive a server restart
+CREATE TABLE IF NOT EXISTS "sessions" (
+    session_id VARCHAR(64) PRIMARY KEY NOT NULL,
+    user_id INTEGER NOT NULL,
+    session_data BYTEA NOT NULL, -- gob encoded session attributes
+    session_created TIMESTAMPTZ NOT NULL,
+    session_accessed TIMESTAMPTZ NOT NULL,
+    session_expires TIMESTAMPTZ NOT NULL,
+    session_idle_timeout INTEGER NOT NULL, -- seconds
+    CONSTRAINT fk_session_user
+        FOREIGN KEY(user_id)
+            REFERENCES users(user_id)
+                ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
@@ -0,0 +1,21 @@
+-- Snapshot of a note every time it is created or edited
+CREATE TABLE IF NOT EXISTS "note_revisions" (
+    revision_id SERIAL PRIMARY KEY NOT NULL,
+    note_id INTEGER NOT NULL,
+    revision_author INTEGER NOT NULL,
+    revision_date TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    note_share INTEGER[],
+    note_name VARCHAR(255) NOT NULL,
+    note_completion_date DATE NOT NULL,
+    note_flag INTEGER NOT NULL,
+    note_content TEXT NOT NULL,
+    CONSTRAINT fk_revision_note
+        FOREIGN KEY(note_id)
+            REFERENCES notes(note_id)
+                ON DELETE CASCADE,
+    CONSTRAINT fk_revision_author
+        FOREIGN KEY(revision_author)
+            REFERENCES users(user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_note_revisions_note_id ON note_revisions(note_id);
@@ -0,0 +1,2 @@
+-- Deleted notes go to the owner's trash until they are restored or purged
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS note_deleted TIMESTAMPTZ;
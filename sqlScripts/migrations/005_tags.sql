@@ -0,0 +1,21 @@
+-- Free-form tags, a note can have many tags and a tag many notes
+CREATE TABLE IF NOT EXISTS "tags" (
+    tag_id SERIAL PRIMARY KEY NOT NULL,
+    tag_name VARCHAR(64) NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS "note_tags" (
+    note_id INTEGER NOT NULL,
+    tag_id INTEGER NOT NULL,
+    PRIMARY KEY(note_id, tag_id),
+    CONSTRAINT fk_note_tags_note
+        FOREIGN KEY(note_id)
+            REFERENCES notes(note_id)
+                ON DELETE CASCADE,
+    CONSTRAINT fk_note_tags_tag
+        FOREIGN KEY(tag_id)
+            REFERENCES tags(tag_id)
+                ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_note_tags_tag_id ON note_tags(tag_id);
@@ -0,0 +1,20 @@
+-- Notebooks group notes and can be nested, sharing a notebook shares every note inside it (and inside its children)
+CREATE TABLE IF NOT EXISTS "notebooks" (
+    notebook_id SERIAL PRIMARY KEY NOT NULL,
+    notebook_owner INTEGER NOT NULL,
+    notebook_parent INTEGER,
+    notebook_name VARCHAR(255) NOT NULL,
+    notebook_share INTEGER[] NOT NULL DEFAULT ARRAY[]::INTEGER[],
+    CONSTRAINT fk_notebook_owner
+        FOREIGN KEY(notebook_owner)
+            REFERENCES users(user_id)
+                ON DELETE CASCADE,
+    CONSTRAINT fk_notebook_parent
+        FOREIGN KEY(notebook_parent)
+            REFERENCES notebooks(notebook_id)
+                ON DELETE CASCADE
+);
+
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS note_notebook INTEGER REFERENCES notebooks(notebook_id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_notes_notebook ON notes(note_notebook);
@@ -0,0 +1,8 @@
+-- Full-text search, the name is weighted above the content so title matches rank first
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS note_search tsvector
+    GENERATED ALWAYS AS (
+        setweight(to_tsvector('english', coalesce(note_name, '')), 'A') ||
+        setweight(to_tsvector('english', coalesce(note_content, '')), 'B')
+    ) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_notes_search ON notes USING GIN(note_search);
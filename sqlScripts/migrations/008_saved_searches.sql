@@ -0,0 +1,15 @@
+-- Named searches, stored as the dashboard query string (e.g. 'q=deploy&tag=infra')
+CREATE TABLE IF NOT EXISTS "saved_searches" (
+    search_id SERIAL PRIMARY KEY NOT NULL,
+    user_id INTEGER NOT NULL,
+    search_name VARCHAR(255) NOT NULL,
+    search_query TEXT NOT NULL,
+    search_pinned BOOLEAN NOT NULL DEFAULT FALSE,
+    search_share INTEGER[] NOT NULL DEFAULT '{}', -- Colleagues the search is shared with
+    CONSTRAINT fk_saved_search_user
+        FOREIGN KEY(user_id)
+            REFERENCES users(user_id)
+                ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_saved_searches_user_id ON saved_searches(user_id);
@@ -0,0 +1,5 @@
+-- Optional deadline and priority of a note, priority 0 means none (see NotePriority* in constants.go)
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS note_due_date DATE;
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS note_priority INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS idx_notes_due_date ON notes(note_due_date) WHERE note_due_date IS NOT NULL;
@@ -0,0 +1,23 @@
+-- Users a delegated note is assigned to, they must be able to see the note
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS note_assignees INTEGER[] NOT NULL DEFAULT '{}';
+
+CREATE INDEX IF NOT EXISTS idx_notes_assignees ON notes USING GIN(note_assignees);
+
+-- Every time a note is assigned or reassigned, who did it and who it went to
+CREATE TABLE IF NOT EXISTS "note_assignments" (
+    assignment_id SERIAL PRIMARY KEY NOT NULL,
+    note_id INTEGER NOT NULL,
+    assigned_by INTEGER NOT NULL,
+    assigned_to INTEGER[] NOT NULL DEFAULT '{}', -- Empty when the note was unassigned
+    assignment_date TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CONSTRAINT fk_assignment_note
+        FOREIGN KEY(note_id)
+            REFERENCES notes(note_id)
+                ON DELETE CASCADE,
+    CONSTRAINT fk_assignment_user
+        FOREIGN KEY(assigned_by)
+            REFERENCES users(user_id)
+                ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_note_assignments_note_id ON note_assignments(note_id);
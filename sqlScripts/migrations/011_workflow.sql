@@ -0,0 +1,40 @@
+-- Note states, note_flag holds a state_id. The built in states keep the ids of the old NoteFlag* constants
+CREATE TABLE IF NOT EXISTS "workflow_states" (
+    state_id SERIAL PRIMARY KEY NOT NULL,
+    state_name VARCHAR(64) NOT NULL UNIQUE,
+    state_colour VARCHAR(7) NOT NULL DEFAULT '#808080', -- #rrggbb
+    state_terminal BOOLEAN NOT NULL DEFAULT FALSE, -- Notes entering a terminal state get their completion date set
+    state_position INTEGER NOT NULL DEFAULT 0
+);
+
+-- Which states a note can be moved to from each state
+CREATE TABLE IF NOT EXISTS "workflow_transitions" (
+    from_state INTEGER NOT NULL,
+    to_state INTEGER NOT NULL,
+    PRIMARY KEY(from_state, to_state),
+    CONSTRAINT fk_transition_from
+        FOREIGN KEY(from_state)
+            REFERENCES workflow_states(state_id)
+                ON DELETE CASCADE,
+    CONSTRAINT fk_transition_to
+        FOREIGN KEY(to_state)
+            REFERENCES workflow_states(state_id)
+                ON DELETE CASCADE
+);
+
+-- Built in states, the first time they are added every state can move to every other like before
+WITH seeded AS (
+    INSERT INTO workflow_states(state_id, state_name, state_colour, state_terminal, state_position)
+    VALUES (0, 'Note', '#808080', FALSE, 0),
+           (1, 'In Progress', '#1e90ff', FALSE, 1),
+           (2, 'Completed', '#2e8b57', TRUE, 2),
+           (3, 'Cancelled', '#a9a9a9', TRUE, 3),
+           (4, 'Delegated', '#9370db', FALSE, 4)
+    ON CONFLICT DO NOTHING
+    RETURNING state_id
+)
+INSERT INTO workflow_transitions(from_state, to_state)
+SELECT f.state_id, t.state_id FROM seeded f, seeded t WHERE f.state_id != t.state_id;
+
+-- The built in states were inserted with their ids so the sequence has to catch up
+SELECT setval(pg_get_serial_sequence('workflow_states', 'state_id'), (SELECT MAX(state_id) FROM workflow_states));
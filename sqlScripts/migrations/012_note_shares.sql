@@ -0,0 +1,21 @@
+-- Role of every user a note is shared with (1 viewer, 2 commenter, 3 editor, 4 co-owner), note_share still lists who can see the note
+CREATE TABLE IF NOT EXISTS "note_shares" (
+    note_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    share_role INTEGER NOT NULL DEFAULT 1,
+    PRIMARY KEY(note_id, user_id),
+    CONSTRAINT fk_share_note
+        FOREIGN KEY(note_id)
+            REFERENCES notes(note_id)
+                ON DELETE CASCADE,
+    CONSTRAINT fk_share_user
+        FOREIGN KEY(user_id)
+            REFERENCES users(user_id)
+                ON DELETE CASCADE
+);
+
+-- Notes shared before roles existed were only viewable
+INSERT INTO note_shares(note_id, user_id, share_role)
+SELECT s.note_id, s.user_id, 1 FROM (SELECT note_id, unnest(note_share) AS user_id FROM notes) s
+WHERE EXISTS (SELECT 1 FROM users WHERE users.user_id=s.user_id)
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,18 @@
+-- Comments left on a note by anyone with at least the commenter role
+CREATE TABLE IF NOT EXISTS "note_comments" (
+    comment_id SERIAL PRIMARY KEY NOT NULL,
+    note_id INTEGER NOT NULL,
+    comment_author INTEGER NOT NULL,
+    comment_content TEXT NOT NULL,
+    comment_date TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CONSTRAINT fk_comment_note
+        FOREIGN KEY(note_id)
+            REFERENCES notes(note_id)
+                ON DELETE CASCADE,
+    CONSTRAINT fk_comment_author
+        FOREIGN KEY(comment_author)
+            REFERENCES users(user_id)
+                ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_note_comments_note_id ON note_comments(note_id);
@@ -0,0 +1,41 @@
+-- Named groups of users that notes can be shared with, 'groups' is a keyword so the table is user_groups
+CREATE TABLE IF NOT EXISTS "user_groups" (
+    group_id SERIAL PRIMARY KEY NOT NULL,
+    group_name VARCHAR(64) NOT NULL UNIQUE
+);
+
+-- Members of each group, admins can rename the group and change its members
+CREATE TABLE IF NOT EXISTS "group_members" (
+    group_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    member_admin BOOLEAN NOT NULL DEFAULT FALSE,
+    PRIMARY KEY(group_id, user_id),
+    CONSTRAINT fk_member_group
+        FOREIGN KEY(group_id)
+            REFERENCES user_groups(group_id)
+                ON DELETE CASCADE,
+    CONSTRAINT fk_member_user
+        FOREIGN KEY(user_id)
+            REFERENCES users(user_id)
+                ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_group_members_user_id ON group_members(user_id);
+
+-- Groups a note is shared with and the role every member gets, access follows the group's current members
+CREATE TABLE IF NOT EXISTS "note_group_shares" (
+    note_id INTEGER NOT NULL,
+    group_id INTEGER NOT NULL,
+    share_role INTEGER NOT NULL DEFAULT 1, -- 1 viewer, 2 commenter, 3 editor, 4 co-owner
+    PRIMARY KEY(note_id, group_id),
+    CONSTRAINT fk_group_share_note
+        FOREIGN KEY(note_id)
+            REFERENCES notes(note_id)
+                ON DELETE CASCADE,
+    CONSTRAINT fk_group_share_group
+        FOREIGN KEY(group_id)
+            REFERENCES user_groups(group_id)
+                ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_note_group_shares_group_id ON note_group_shares(group_id);
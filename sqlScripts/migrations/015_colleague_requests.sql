@@ -0,0 +1,38 @@
+-- Colleague invitations waiting for the recipient to accept or decline, accepted ones are kept in user_settings.colleagues on both sides
+CREATE TABLE IF NOT EXISTS "colleague_requests" (
+    request_id SERIAL PRIMARY KEY NOT NULL,
+    requester INTEGER NOT NULL,
+    recipient INTEGER NOT NULL,
+    request_date TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE(requester, recipient),
+    CONSTRAINT fk_request_requester
+        FOREIGN KEY(requester)
+            REFERENCES users(user_id)
+                ON DELETE CASCADE,
+    CONSTRAINT fk_request_recipient
+        FOREIGN KEY(recipient)
+            REFERENCES users(user_id)
+                ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_colleague_requests_recipient ON colleague_requests(recipient);
+
+-- Users that only accept notes from their colleagues
+ALTER TABLE user_settings ADD COLUMN IF NOT EXISTS share_colleagues_only BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Colleagues used to be one sided, one sided entries become invitations from the user that added them
+INSERT INTO colleague_requests(requester, recipient)
+SELECT s.user_id, c.id FROM user_settings s, unnest(s.colleagues) AS c(id)
+WHERE c.id<>s.user_id
+AND EXISTS (SELECT 1 FROM users WHERE users.user_id=c.id)
+AND NOT EXISTS (SELECT 1 FROM user_settings o WHERE o.user_id=c.id AND s.user_id=ANY(o.colleagues))
+ON CONFLICT DO NOTHING;
+
+UPDATE user_settings s SET colleagues=ARRAY(
+    SELECT c.id FROM unnest(s.colleagues) AS c(id)
+    WHERE EXISTS (SELECT 1 FROM user_settings o WHERE o.user_id=c.id AND s.user_id=ANY(o.colleagues))
+)
+WHERE EXISTS (
+    SELECT 1 FROM unnest(s.colleagues) AS c(id)
+    WHERE NOT EXISTS (SELECT 1 FROM user_settings o WHERE o.user_id=c.id AND s.user_id=ANY(o.colleagues))
+);
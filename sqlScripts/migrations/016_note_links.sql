@@ -0,0 +1,23 @@
+-- Public read-only links to a note, only a hash of each link's token is stored
+CREATE TABLE IF NOT EXISTS "note_links" (
+    link_id SERIAL PRIMARY KEY NOT NULL,
+    note_id INTEGER NOT NULL,
+    link_created_by INTEGER NOT NULL,
+    link_label VARCHAR(255) NOT NULL DEFAULT '',
+    link_hash CHAR(64) NOT NULL UNIQUE,
+    link_password VARCHAR(255) NOT NULL DEFAULT '', -- bcrypt hash, empty if the link has no password
+    link_created TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    link_expires TIMESTAMPTZ, -- NULL if the link never expires
+    link_views INTEGER NOT NULL DEFAULT 0,
+    link_last_viewed TIMESTAMPTZ,
+    CONSTRAINT fk_link_note
+        FOREIGN KEY(note_id)
+            REFERENCES notes(note_id)
+                ON DELETE CASCADE,
+    CONSTRAINT fk_link_user
+        FOREIGN KEY(link_created_by)
+            REFERENCES users(user_id)
+                ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_note_links_note_id ON note_links(note_id);
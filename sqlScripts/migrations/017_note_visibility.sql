@@ -0,0 +1,29 @@
+-- Who can see a note: 0 private, 1 the users and groups it is shared with, 2 every user (see NoteVisibility* in constants.go).
+-- Before this an empty note_share meant every user and [-1] meant nobody
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS note_visibility INTEGER;
+ALTER TABLE note_revisions ADD COLUMN IF NOT EXISTS note_visibility INTEGER;
+
+UPDATE notes SET note_visibility = CASE
+    WHEN COALESCE(cardinality(note_share), 0)=0 THEN 2
+    WHEN note_share @> ARRAY[-1] AND cardinality(array_remove(note_share, -1))=0
+        AND NOT EXISTS (SELECT 1 FROM note_group_shares gs WHERE gs.note_id=notes.note_id) THEN 0
+    ELSE 1
+END
+WHERE note_visibility IS NULL;
+
+-- Revisions don't record group shares, so they are assumed to be the note's current ones
+UPDATE note_revisions SET note_visibility = CASE
+    WHEN COALESCE(cardinality(note_share), 0)=0 THEN 2
+    WHEN cardinality(array_remove(note_share, -1))=0
+        AND NOT EXISTS (SELECT 1 FROM note_group_shares gs WHERE gs.note_id=note_revisions.note_id) THEN 0
+    ELSE 1
+END
+WHERE note_visibility IS NULL;
+
+UPDATE notes SET note_share = array_remove(note_share, -1) WHERE note_share @> ARRAY[-1];
+UPDATE note_revisions SET note_share = array_remove(note_share, -1) WHERE note_share @> ARRAY[-1];
+
+ALTER TABLE notes ALTER COLUMN note_visibility SET DEFAULT 0;
+ALTER TABLE notes ALTER COLUMN note_visibility SET NOT NULL;
+ALTER TABLE note_revisions ALTER COLUMN note_visibility SET DEFAULT 0;
+ALTER TABLE note_revisions ALTER COLUMN note_visibility SET NOT NULL;
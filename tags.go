@@ -0,0 +1,149 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+/* - How many notes have a tag - */
+type TagCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+/*
+- Puts a tag into its stored form: trimmed, lower case and with spaces replaced by '-'
+Args:
+
+	tag: tag as typed by the user
+
+return: the normalised tag, empty if nothing is left
+*/
+func normaliseTag(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	tag = strings.Join(strings.Fields(tag), "-")
+	return truncateRunes(tag, TagNameMaxLength)
+}
+
+/*
+- Reads a comma separated list of tags (e.g. "infra, on call")
+Args:
+
+	s: tags as typed by the user
+
+return: list of unique normalised tags
+*/
+func parseTags(s string) []string {
+	tags := []string{}
+	for _, raw := range strings.Split(s, ",") {
+		tag := normaliseTag(raw)
+		if tag != "" && !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+/*
+- Replaces the tags of a note, creating any tags that don't exist yet
+Args:
+
+	tx: transaction the note is being written in
+	noteId: note_id of the note
+	tags: normalised tags
+
+return: nil or an error
+*/
+func setNoteTags(tx *sql.Tx, noteId int32, tags []string) error {
+	rows, err := tx.Query("DELETE FROM note_tags WHERE note_id=$1 RETURNING tag_id", noteId)
+	if err != nil {
+		return err
+	}
+	var dropped pq.Int32Array
+	for rows.Next() {
+		var id int32
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		dropped = append(dropped, id)
+	}
+	rows.Close()
+
+	for _, tag := range tags {
+		// Updating an existing tag locks it so it can't be removed by another note's save until this one commits
+		var tagId int32
+		err = tx.QueryRow("INSERT INTO tags(tag_name) VALUES($1) ON CONFLICT (tag_name) DO UPDATE SET tag_name=EXCLUDED.tag_name RETURNING tag_id", tag).Scan(&tagId)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("INSERT INTO note_tags(note_id, tag_id) VALUES($1, $2) ON CONFLICT DO NOTHING", noteId, tagId)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Tags the note no longer has are removed if no other note has them, so they stop being suggested
+	_, err = tx.Exec("DELETE FROM tags t WHERE t.tag_id=ANY($1) AND NOT EXISTS (SELECT 1 FROM note_tags nt WHERE nt.tag_id=t.tag_id)", dropped)
+	return err
+}
+
+/*
+- Counts how many of the notes a user can see have each tag, notes in the trash aren't counted
+Args:
+
+	user: user the tags are counted for
+
+return: tag counts, most used first then by name, or an error
+*/
+func (a *App) fetchTagCounts(user User) ([]TagCount, error) {
+	rows, err := a.db.Query("SELECT t.tag_name, COUNT(*) FROM note_tags nt JOIN tags t ON t.tag_id=nt.tag_id JOIN notes ON notes.note_id=nt.note_id "+
+		"WHERE note_deleted IS NULL AND "+noteVisibleCondition("$1")+" GROUP BY t.tag_name ORDER BY COUNT(*) DESC, t.tag_name",
+		user.Id)
+	if err != nil {
+		return make([]TagCount, 0), err
+	}
+	defer rows.Close()
+
+	tagCounts := []TagCount{}
+	for rows.Next() {
+		var tc TagCount
+		if e := rows.Scan(&tc.Name, &tc.Count); e != nil {
+			return make([]TagCount, 0), e
+		}
+		tagCounts = append(tagCounts, tc)
+	}
+
+	return tagCounts, nil
+}
+
+// GET /api/v1/tags?prefix=
+// Only tags on notes the user can see are listed so private tags aren't leaked
+func (a *App) apiListTagsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.apiCurrentUser(w, r)
+	if !ok {
+		return
+	}
+
+	tagCounts, err := a.fetchTagCounts(user)
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	prefix := normaliseTag(r.URL.Query().Get("prefix"))
+
+	tags := []TagCount{}
+	for _, tag := range tagCounts {
+		if strings.HasPrefix(tag.Name, prefix) {
+			tags = append(tags, tag)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, tags)
+}
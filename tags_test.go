@@ -0,0 +1,68 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestNormaliseTag(t *testing.T) {
+	tests := []struct {
+		tag, want string
+	}{
+		{"infra", "infra"},
+		{"  On Call ", "on-call"},
+		{"a \t b", "a-b"},
+		{"   ", ""},
+		{strings.Repeat("x", 100), strings.Repeat("x", TagNameMaxLength)},
+		{strings.Repeat("é", 100), strings.Repeat("é", TagNameMaxLength)},
+	}
+
+	for _, tt := range tests {
+		got := normaliseTag(tt.tag)
+		if got != tt.want {
+			t.Errorf("normaliseTag(%q) = %q, want %q", tt.tag, got, tt.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("normaliseTag(%q) = %q isn't valid UTF-8", tt.tag, got)
+		}
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []string
+	}{
+		{"", []string{}},
+		{"infra, on call", []string{"infra", "on-call"}},
+		{"a,,A, b ,a", []string{"a", "b"}},
+	}
+
+	for _, tt := range tests {
+		if got := parseTags(tt.s); !slices.Equal(got, tt.want) {
+			t.Errorf("parseTags(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestTruncateRunes(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"hello", 10, "hello"},
+		{"hello", 3, "hel"},
+		{"héllo", 2, "hé"},
+		{"日本語", 2, "日本"},
+		{"abc", 0, ""},
+	}
+
+	for _, tt := range tests {
+		if got := truncateRunes(tt.s, tt.n); got != tt.want {
+			t.Errorf("truncateRunes(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Every token starts with this so they are easy to spot (e.g. in leaked logs)
+const apiTokenPrefix = "nsa_"
+
+type contextKey int
+
+const tokenUserKey contextKey = 0
+
+/* - Token as it is sent by the api (never includes the hash) - */
+type apiTokenInfo struct {
+	Id       int32      `json:"id"`
+	Name     string     `json:"name"`
+	Scope    int        `json:"scope"`
+	Created  time.Time  `json:"created"`
+	LastUsed *time.Time `json:"last_used"`
+	Token    string     `json:"token,omitempty"`
+}
+
+/* - A secret (e.g. a new api token) shown to the user once, it is never stored where it could be read back - */
+type SecretData struct {
+	Title   string
+	Message string
+	Secret  string
+	Back    string // page to go back to
+}
+
+/* - Body of a create token request - */
+type apiTokenRequest struct {
+	Name  string `json:"name"`
+	Scope int    `json:"scope"`
+}
+
+func hashApiToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+/*
+- Creates a new api token for a user
+Args:
+
+	user: owner of the token
+	name: name to remember the token by
+	scope: one of the TokenScope* constants
+
+return: the token (only ever available here), its database entry or an error
+*/
+func (a *App) createApiToken(user User, name string, scope int) (string, ApiToken, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", ApiToken{}, err
+	}
+	token := apiTokenPrefix + hex.EncodeToString(raw)
+
+	apiToken := ApiToken{
+		UserId: user.Id,
+		Name:   truncateRunes(name, TokenNameMaxLength),
+		Hash:   hashApiToken(token),
+		Scope:  scope,
+	}
+
+	err := a.db.QueryRow("INSERT INTO api_tokens(user_id, token_name, token_hash, token_scope) VALUES($1, $2, $3, $4) RETURNING token_id, token_created",
+		apiToken.UserId, apiToken.Name, apiToken.Hash, apiToken.Scope).Scan(&apiToken.Id, &apiToken.Created)
+	if err != nil {
+		return "", ApiToken{}, err
+	}
+
+	return token, apiToken, nil
+}
+
+/*
+- Fetches every api token belonging to a user
+Args:
+
+	user: owner of the tokens
+
+return: list of tokens or an error
+*/
+func (a *App) fetchApiTokens(user User) ([]ApiToken, error) {
+	rows, err := a.db.Query("SELECT token_id, user_id, token_name, token_hash, token_scope, token_created, token_last_used FROM api_tokens WHERE user_id=$1 ORDER BY token_id",
+		user.Id)
+	if err != nil {
+		return make([]ApiToken, 0), err
+	}
+	defer rows.Close()
+
+	tokens := []ApiToken{}
+	for rows.Next() {
+		var t ApiToken
+		if e := rows.Scan(&t.Id, &t.UserId, &t.Name, &t.Hash, &t.Scope, &t.Created, &t.LastUsed); e != nil {
+			return make([]ApiToken, 0), e
+		}
+		tokens = append(tokens, t)
+	}
+
+	return tokens, nil
+}
+
+/*
+- Revokes (deletes) one of a user's api tokens
+Args:
+
+	user: owner of the token
+	id: token_id
+
+return: false if the user has no such token, or an error
+*/
+func (a *App) revokeApiToken(user User, id int32) (bool, error) {
+	res, err := a.db.Exec("DELETE FROM api_tokens WHERE token_id=$1 AND user_id=$2", id, user.Id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+/*
+- Looks up the user an api token belongs to and marks the token as used
+Args:
+
+	token: token sent by the client
+
+return: the user, the token's scope or an error (sql.ErrNoRows if the token is unknown)
+*/
+func (a *App) lookupApiToken(token string) (User, int, error) {
+	var user User
+	var scope int
+	err := a.db.QueryRow("UPDATE api_tokens t SET token_last_used=NOW() FROM users u WHERE t.token_hash=$1 AND u.user_id=t.user_id RETURNING u.user_id, u.username, u.pass, t.token_scope",
+		hashApiToken(token)).Scan(&user.Id, &user.Username, &user.Password, &scope)
+	if err != nil {
+		return User{}, 0, err
+	}
+	return user, scope, nil
+}
+
+/*
+- Middleware that authenticates requests sent with 'Authorization: Bearer <token>'.
+- Requests without the header are passed through untouched so the session cookie is used instead.
+*/
+func (a *App) tokenAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		token, found := strings.CutPrefix(header, "Bearer ")
+		if header == "" || !found {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		isApi := strings.HasPrefix(r.URL.Path, "/api/")
+
+		user, scope, err := a.lookupApiToken(strings.TrimSpace(token))
+		switch {
+		case err == sql.ErrNoRows:
+			if isApi {
+				writeJSONError(w, http.StatusUnauthorized, "invalid api token")
+			} else {
+				http.Error(w, "invalid api token", http.StatusUnauthorized)
+			}
+			return
+		case err != nil:
+			checkInternalServerError(err, w)
+			return
+		}
+
+		// Read only tokens can't change anything
+		if scope == TokenScopeReadOnly && r.Method != "GET" && r.Method != "HEAD" {
+			if isApi {
+				writeJSONError(w, http.StatusForbidden, "api token is read only")
+			} else {
+				forbidden(w)
+			}
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), tokenUserKey, user)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+/*
+- Gets the user authenticated by an api token
+Args:
+
+	r: http request
+
+return: the user and true if the request was sent with a valid token
+*/
+func tokenUserFromRequest(r *http.Request) (User, bool) {
+	user, ok := r.Context().Value(tokenUserKey).(User)
+	return user, ok
+}
+
+func toApiTokenInfo(t ApiToken) apiTokenInfo {
+	info := apiTokenInfo{
+		Id:      t.Id,
+		Name:    t.Name,
+		Scope:   t.Scope,
+		Created: t.Created,
+	}
+	if t.LastUsed.Valid {
+		info.LastUsed = &t.LastUsed.Time
+	}
+	return info
+}
+
+func (a *App) createTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAuthenticated(w, r) {
+		return
+	}
+
+	user, err := a.fetchCurrentUser(r)
+	checkInternalServerError(err, w)
+
+	name := r.FormValue("token-name")
+	scope, err := strconv.Atoi(r.FormValue("token-scope"))
+	if err != nil || scope < 0 || scope >= TokenScopeMax || name == "" {
+		setFlash(w, FlashDashboard, "A token needs a name and a valid scope")
+		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		return
+	}
+
+	token, _, err := a.createApiToken(user, name, scope)
+	if err != nil {
+		checkInternalServerError(err, w)
+		return
+	}
+
+	showSecret(w, SecretData{
+		Title:   "New API Token",
+		Message: "Copy the token now, it won't be shown again.",
+		Secret:  token,
+		Back:    "/dashboard",
+	})
+}
+
+/*
+- Renders a secret straight into the response so it never ends up in a cookie or a redirect
+Args:
+
+	w: http response writer
+	data: secret to show
+*/
+func showSecret(w http.ResponseWriter, data SecretData) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	executeTemplate(w, "secret.html", "web/secret.html", template.FuncMap{}, data)
+}
+
+func (a *App) revokeTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAuthenticated(w, r) {
+		return
+	}
+
+	user, err := a.fetchCurrentUser(r)
+	checkInternalServerError(err, w)
+
+	id, err := getIdFromPath(r)
+	if err != nil {
+		http.Error(w, "invalid token id", http.StatusBadRequest)
+		return
+	}
+
+	_, err = a.revokeApiToken(user, id)
+	checkInternalServerError(err, w)
+
+	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+}
+
+// GET /api/v1/tokens
+func (a *App) apiListTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.apiCurrentUser(w, r)
+	if !ok {
+		return
+	}
+
+	tokens, err := a.fetchApiTokens(user)
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	infos := make([]apiTokenInfo, 0, len(tokens))
+	for _, t := range tokens {
+		infos = append(infos, toApiTokenInfo(t))
+	}
+
+	writeJSON(w, http.StatusOK, infos)
+}
+
+// POST /api/v1/tokens
+func (a *App) apiCreateTokenHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.apiCurrentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req apiTokenRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	if req.Name == "" || req.Scope < 0 || req.Scope >= TokenScopeMax {
+		writeJSONError(w, http.StatusBadRequest, "a token needs a name and a valid scope")
+		return
+	}
+
+	token, apiToken, err := a.createApiToken(user, req.Name, req.Scope)
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	info := toApiTokenInfo(apiToken)
+	info.Token = token
+	writeJSON(w, http.StatusCreated, info)
+}
+
+// DELETE /api/v1/tokens/{id}
+func (a *App) apiRevokeTokenHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.apiCurrentUser(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := getIdFromPath(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid token id")
+		return
+	}
+
+	found, err := a.revokeApiToken(user, id)
+	switch {
+	case err != nil:
+		writeJSONInternalError(w, err)
+	case !found:
+		writeJSONError(w, http.StatusNotFound, "token not found")
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+- Chooses how long notes stay in the trash before they are purged
+return: TRASH_RETENTION_DAYS if it is set to a valid number of days, otherwise DefaultTrashRetention
+*/
+func findTrashRetention() time.Duration {
+	retention := DefaultTrashRetention
+
+	if days, err := strconv.Atoi(os.Getenv("TRASH_RETENTION_DAYS")); err == nil && days > 0 {
+		retention = time.Duration(days) * 24 * time.Hour
+	}
+
+	return retention
+}
+
+/*
+- Periodically deletes notes that have been in the trash longer than a.trashRetention.
+- This method is to be started as a new goroutine.
+*/
+func (a *App) trashPurger(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		res, err := a.db.Exec("DELETE FROM notes WHERE note_deleted IS NOT NULL AND note_deleted < $1",
+			time.Now().Add(-a.trashRetention))
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			log.Printf("Purged %d notes from the trash", n)
+		}
+	}
+}
+
+/*
+- Fetches the notes in a user's trash, most recently deleted first
+Args:
+
+	user: owner of the trash
+
+return: list of notes or an error
+*/
+func (a *App) fetchTrash(user User) ([]Note, error) {
+	rows, err := a.db.Query("SELECT "+noteColumns+" FROM notes WHERE note_owner=$1 AND note_deleted IS NOT NULL ORDER BY note_deleted DESC", user.Id)
+	if err != nil {
+		return make([]Note, 0), err
+	}
+	defer rows.Close()
+
+	notes := []Note{}
+	for rows.Next() {
+		note, e := scanNote(rows)
+		if e != nil {
+			return make([]Note, 0), e
+		}
+		notes = append(notes, note)
+	}
+
+	return notes, nil
+}
+
+/*
+- Fetches a note from a user's trash
+Args:
+
+	user: owner of the trash
+	id: note_id of the note
+
+return: the note or an error (sql.ErrNoRows if it isn't in the user's trash)
+*/
+func (a *App) fetchTrashedNote(user User, id int32) (Note, error) {
+	return scanNote(a.db.QueryRow("SELECT "+noteColumns+" FROM notes WHERE note_id=$1 AND note_owner=$2 AND note_deleted IS NOT NULL", id, user.Id))
+}
+
+/*
+- Moves a note out of the trash
+*/
+func (a *App) restoreNote(note Note) error {
+	_, err := a.db.Exec("UPDATE notes SET note_deleted=NULL WHERE note_id=$1", note.Id)
+	return err
+}
+
+/*
+- Permanently deletes a note that is in the trash
+*/
+func (a *App) purgeNote(note Note) error {
+	_, err := a.db.Exec("DELETE FROM notes WHERE note_id=$1 AND note_deleted IS NOT NULL", note.Id)
+	return err
+}
+
+/*
+- Gets the note in the request path from the current user's trash
+Args:
+
+	w: http response writer
+	r: http request
+
+return: the note and true, or false if a response has already been sent
+*/
+func (a *App) trashedNoteFromRequest(w http.ResponseWriter, r *http.Request) (Note, bool) {
+	user, err := a.fetchCurrentUser(r)
+	if err != nil {
+		checkInternalServerError(err, w)
+		return Note{}, false
+	}
+
+	id, err := getIdFromPath(r)
+	if err != nil {
+		http.Error(w, "invalid note id", http.StatusBadRequest)
+		return Note{}, false
+	}
+
+	note, err := a.fetchTrashedNote(user, id)
+	switch {
+	case err == sql.ErrNoRows:
+		setFlash(w, FlashDashboard, "That note isn't in your trash")
+		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		return Note{}, false
+	case err != nil:
+		checkInternalServerError(err, w)
+		return Note{}, false
+	}
+
+	return note, true
+}
+
+func (a *App) restoreNoteHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAuthenticated(w, r) {
+		return
+	}
+
+	note, ok := a.trashedNoteFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	err := a.restoreNote(note)
+	checkInternalServerError(err, w)
+
+	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+}
+
+func (a *App) purgeNoteHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAuthenticated(w, r) {
+		return
+	}
+
+	note, ok := a.trashedNoteFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	err := a.purgeNote(note)
+	checkInternalServerError(err, w)
+
+	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+}
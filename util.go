@@ -85,3 +85,17 @@ func minInt(x int, y int) int {
 	}
 	return x
 }
+
+/*
+- Cuts a string to at most n characters without splitting a multi-byte character
+*/
+func truncateRunes(s string, n int) string {
+	i := 0
+	for pos := range s {
+		if i == n {
+			return s[:pos]
+		}
+		i++
+	}
+	return s
+}
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SQL condition matching notes in a terminal state
+const terminalStateCondition = "note_flag IN (SELECT state_id FROM workflow_states WHERE state_terminal)"
+
+// State colours are picked with <input type="color">
+var stateColour = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+/* - The workflow states in order and which states a note can move to from each one - */
+type Workflow struct {
+	States      []WorkflowState
+	Transitions map[int][]int // state_id to the state_ids a note in that state can be moved to
+}
+
+type WorkflowData struct {
+	CurrentUser User
+	Workflow    Workflow
+	FlashMsg    string
+}
+
+/* - Workflow state as it is sent by the api - */
+type apiWorkflowState struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name"`
+	Colour      string `json:"colour"`
+	Terminal    bool   `json:"terminal"`
+	Transitions []int  `json:"transitions"` // states a note can be moved to from this one
+}
+
+/*
+- Fetches the workflow states and transitions
+return: the workflow or an error
+*/
+func (a *App) fetchWorkflow() (Workflow, error) {
+	wf := Workflow{States: []WorkflowState{}, Transitions: map[int][]int{}}
+
+	rows, err := a.db.Query("SELECT state_id, state_name, state_colour, state_terminal, state_position FROM workflow_states ORDER BY state_position, state_id")
+	if err != nil {
+		return wf, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var state WorkflowState
+		if e := rows.Scan(&state.Id, &state.Name, &state.Colour, &state.Terminal, &state.Position); e != nil {
+			return wf, e
+		}
+		wf.States = append(wf.States, state)
+	}
+
+	transitions, err := a.db.Query("SELECT from_state, to_state FROM workflow_transitions")
+	if err != nil {
+		return wf, err
+	}
+	defer transitions.Close()
+
+	for transitions.Next() {
+		var from, to int
+		if e := transitions.Scan(&from, &to); e != nil {
+			return wf, e
+		}
+		wf.Transitions[from] = append(wf.Transitions[from], to)
+	}
+
+	return wf, nil
+}
+
+/*
+- Finds a workflow state by its id
+return: the state and true, or false if there is no such state
+*/
+func (wf Workflow) state(id int) (WorkflowState, bool) {
+	i := slices.IndexFunc(wf.States, func(s WorkflowState) bool { return s.Id == id })
+	if i == -1 {
+		return WorkflowState{}, false
+	}
+	return wf.States[i], true
+}
+
+/*
+- Gets the display name of a workflow state
+*/
+func (wf Workflow) stateName(id int) string {
+	if state, ok := wf.state(id); ok {
+		return state.Name
+	}
+	return "Unknown"
+}
+
+/*
+- Gets the colour of a workflow state, grey if there is no such state
+*/
+func (wf Workflow) stateColour(id int) string {
+	if state, ok := wf.state(id); ok {
+		return state.Colour
+	}
+	return "#808080"
+}
+
+/*
+- Finds a workflow state by name such as 'inprogress' or 'In Progress', case and spaces are ignored
+return: the state_id and true, or false if there is no such state
+*/
+func (wf Workflow) findState(name string) (int, bool) {
+	simplify := func(s string) string {
+		return strings.ToLower(strings.NewReplacer(" ", "", "-", "", "_", "").Replace(s))
+	}
+
+	for _, state := range wf.States {
+		if simplify(state.Name) == simplify(name) {
+			return state.Id, true
+		}
+	}
+	return 0, false
+}
+
+/*
+- SQL condition matching notes that were completed: every terminal state apart from the built in Cancelled,
+so states admins add count as completed when they are terminal
+*/
+func (wf Workflow) completedCondition() string {
+	ids := []string{}
+	for _, state := range wf.States {
+		if state.Terminal && state.Id != NoteFlagCancelled {
+			ids = append(ids, strconv.Itoa(state.Id))
+		}
+	}
+
+	if len(ids) == 0 {
+		return "FALSE"
+	}
+	return "note_flag IN (" + strings.Join(ids, ", ") + ")"
+}
+
+/*
+- Checks if a note can be moved from one state to another, staying in the same state is always allowed
+*/
+func (wf Workflow) canMove(from, to int) bool {
+	return from == to || slices.Contains(wf.Transitions[from], to)
+}
+
+/*
+- Moves a note to another workflow state, the completion date is set when it enters a terminal state
+Args:
+
+	note: note to move
+	to: state_id to move it to
+
+return: an error message if the state doesn't exist or the move isn't allowed, otherwise an empty string
+*/
+func (wf Workflow) moveNote(note *Note, to int) string {
+	state, ok := wf.state(to)
+	if !ok {
+		return "unknown note status"
+	}
+	if !wf.canMove(note.Flag, to) {
+		return "a note can't go from " + wf.stateName(note.Flag) + " to " + state.Name
+	}
+
+	if note.Flag != to && state.Terminal {
+		note.CompletionDate = time.Now()
+	}
+	note.Flag = to
+	note.Terminal = state.Terminal
+	return ""
+}
+
+/*
+- Puts a new note in a workflow state, unlike moveNote any state can be the first one
+Args:
+
+	note: note that hasn't been saved yet
+	state: state_id to start it in
+
+return: an error message if the state doesn't exist, otherwise an empty string
+*/
+func (wf Workflow) startNote(note *Note, state int) string {
+	s, ok := wf.state(state)
+	if !ok {
+		return "unknown note status"
+	}
+
+	note.Flag = state
+	note.Terminal = s.Terminal
+	note.CompletionDate = time.Now()
+	return ""
+}
+
+/*
+- Replaces every workflow transition
+Args:
+
+	transitions: state_id to the state_ids a note in that state can be moved to
+
+return: nil or an error
+*/
+func (a *App) setTransitions(transitions map[int][]int) error {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec("DELETE FROM workflow_transitions"); err != nil {
+		return err
+	}
+
+	for from, tos := range transitions {
+		for _, to := range tos {
+			_, err = tx.Exec("INSERT INTO workflow_transitions(from_state, to_state) VALUES($1, $2) ON CONFLICT DO NOTHING", from, to)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+/*
+- Reads a new or edited state from the workflow form
+Args:
+
+	r: http request
+	prefix: 'create' or 'edit'
+
+return: the state (without an id) or an error message
+*/
+func readStateForm(r *http.Request, prefix string) (WorkflowState, string) {
+	state := WorkflowState{
+		Name:     strings.TrimSpace(r.FormValue(prefix + "-state-name")),
+		Colour:   r.FormValue(prefix + "-state-colour"),
+		Terminal: r.FormValue(prefix+"-state-terminal") != "",
+	}
+	state.Position, _ = strconv.Atoi(r.FormValue(prefix + "-state-position"))
+
+	switch {
+	case state.Name == "":
+		return state, "A state needs a name"
+	case len(state.Name) > StateNameMaxLength:
+		return state, "State names can be at most " + strconv.Itoa(StateNameMaxLength) + " characters"
+	case !stateColour.MatchString(state.Colour):
+		return state, "State colours are written as #rrggbb"
+	}
+	return state, ""
+}
+
+/*
+- Checks if a user is allowed to change the workflow, writes a 403 if they aren't
+return: true if the user is an admin
+*/
+func (a *App) requireAdmin(w http.ResponseWriter, user User) bool {
+	if !a.isAdmin(user) {
+		forbidden(w)
+		return false
+	}
+	return true
+}
+
+func (a *App) workflowHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAuthenticated(w, r) {
+		return
+	}
+
+	user, err := a.fetchCurrentUser(r)
+	checkInternalServerError(err, w)
+
+	if !a.requireAdmin(w, user) {
+		return
+	}
+
+	wf, err := a.fetchWorkflow()
+	checkInternalServerError(err, w)
+
+	tmplData := WorkflowData{
+		CurrentUser: user,
+		Workflow:    wf,
+		FlashMsg:    popFlash(w, r, FlashDashboard),
+	}
+
+	executeTemplate(w, "workflow.html", "web/workflow.html",
+		template.FuncMap{
+			"canMove": wf.canMove,
+			"isBuiltIn": func(state WorkflowState) bool {
+				return state.Id < NoteFlagMax
+			},
+		},
+		tmplData)
+}
+
+func (a *App) createStateHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAuthenticated(w, r) {
+		return
+	}
+
+	user, err := a.fetchCurrentUser(r)
+	checkInternalServerError(err, w)
+
+	if !a.requireAdmin(w, user) {
+		return
+	}
+
+	state, msg := readStateForm(r, "create")
+	if msg != "" {
+		setFlash(w, FlashDashboard, msg)
+		http.Redirect(w, r, "/workflow", http.StatusSeeOther)
+		return
+	}
+
+	_, err = a.db.Exec("INSERT INTO workflow_states(state_name, state_colour, state_terminal, state_position) VALUES($1, $2, $3, $4) ON CONFLICT (state_name) DO NOTHING",
+		state.Name, state.Colour, state.Terminal, state.Position)
+	checkInternalServerError(err, w)
+
+	http.Redirect(w, r, "/workflow", http.StatusSeeOther)
+}
+
+func (a *App) editStateHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAuthenticated(w, r) {
+		return
+	}
+
+	user, err := a.fetchCurrentUser(r)
+	checkInternalServerError(err, w)
+
+	if !a.requireAdmin(w, user) {
+		return
+	}
+
+	id, err := getIdFromPath(r)
+	if err != nil {
+		http.Error(w, "invalid state id", http.StatusBadRequest)
+		return
+	}
+
+	state, msg := readStateForm(r, "edit")
+	if msg != "" {
+		setFlash(w, FlashDashboard, msg)
+		http.Redirect(w, r, "/workflow", http.StatusSeeOther)
+		return
+	}
+
+	_, err = a.db.Exec("UPDATE workflow_states SET state_name=$1, state_colour=$2, state_terminal=$3, state_position=$4 WHERE state_id=$5",
+		state.Name, state.Colour, state.Terminal, state.Position, id)
+	if err != nil {
+		// Most likely another state already has the name
+		setFlash(w, FlashDashboard, "Couldn't rename the state to '"+state.Name+"', is the name already used?")
+	}
+
+	http.Redirect(w, r, "/workflow", http.StatusSeeOther)
+}
+
+func (a *App) deleteStateHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAuthenticated(w, r) {
+		return
+	}
+
+	user, err := a.fetchCurrentUser(r)
+	checkInternalServerError(err, w)
+
+	if !a.requireAdmin(w, user) {
+		return
+	}
+
+	id, err := getIdFromPath(r)
+	if err != nil {
+		http.Error(w, "invalid state id", http.StatusBadRequest)
+		return
+	}
+
+	if id < NoteFlagMax {
+		setFlash(w, FlashDashboard, "Built in states can be renamed but not deleted")
+		http.Redirect(w, r, "/workflow", http.StatusSeeOther)
+		return
+	}
+
+	// Notes in the trash count too, they would have no state once restored
+	res, err := a.db.Exec("DELETE FROM workflow_states WHERE state_id=$1 AND NOT EXISTS (SELECT 1 FROM notes WHERE note_flag=$1)", id)
+	checkInternalServerError(err, w)
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		setFlash(w, FlashDashboard, "Only states no note is in can be deleted")
+	}
+
+	http.Redirect(w, r, "/workflow", http.StatusSeeOther)
+}
+
+func (a *App) setTransitionsHandler(w http.ResponseWriter, r *http.Request) {
+	if !isAuthenticated(w, r) {
+		return
+	}
+
+	user, err := a.fetchCurrentUser(r)
+	checkInternalServerError(err, w)
+
+	if !a.requireAdmin(w, user) {
+		return
+	}
+
+	wf, err := a.fetchWorkflow()
+	checkInternalServerError(err, w)
+
+	// Each checked box is 'from-to'
+	r.ParseForm()
+	transitions := map[int][]int{}
+	for _, v := range r.Form["transition"] {
+		fromRaw, toRaw, _ := strings.Cut(v, "-")
+		from, errFrom := strconv.Atoi(fromRaw)
+		to, errTo := strconv.Atoi(toRaw)
+		_, fromOk := wf.state(from)
+		_, toOk := wf.state(to)
+		if errFrom == nil && errTo == nil && fromOk && toOk && from != to {
+			transitions[from] = append(transitions[from], to)
+		}
+	}
+
+	err = a.setTransitions(transitions)
+	checkInternalServerError(err, w)
+
+	http.Redirect(w, r, "/workflow", http.StatusSeeOther)
+}
+
+// GET /api/v1/workflow
+func (a *App) apiGetWorkflowHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := a.apiCurrentUser(w, r); !ok {
+		return
+	}
+
+	wf, err := a.fetchWorkflow()
+	if err != nil {
+		writeJSONInternalError(w, err)
+		return
+	}
+
+	states := make([]apiWorkflowState, 0, len(wf.States))
+	for _, state := range wf.States {
+		transitions := wf.Transitions[state.Id]
+		if transitions == nil {
+			transitions = []int{}
+		}
+		states = append(states, apiWorkflowState{
+			Id:          state.Id,
+			Name:        state.Name,
+			Colour:      state.Colour,
+			Terminal:    state.Terminal,
+			Transitions: transitions,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, states)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+var moveWorkflow = Workflow{
+	States: []WorkflowState{
+		{Id: NoteFlagNote, Name: "Note"},
+		{Id: NoteFlagInProgress, Name: "In Progress"},
+		{Id: NoteFlagCompleted, Name: "Completed", Terminal: true},
+		{Id: NoteFlagCancelled, Name: "Cancelled", Terminal: true},
+	},
+	Transitions: map[int][]int{
+		NoteFlagNote:       {NoteFlagInProgress, NoteFlagCancelled},
+		NoteFlagInProgress: {NoteFlagCompleted, NoteFlagNote},
+		NoteFlagCompleted:  {NoteFlagInProgress},
+	},
+}
+
+func TestFindState(t *testing.T) {
+	tests := []struct {
+		name   string
+		wantId int
+		wantOk bool
+	}{
+		{"Note", NoteFlagNote, true},
+		{"inprogress", NoteFlagInProgress, true},
+		{"In-Progress", NoteFlagInProgress, true},
+		{"in_progress", NoteFlagInProgress, true},
+		{"done", 0, false},
+	}
+
+	for _, tt := range tests {
+		id, ok := moveWorkflow.findState(tt.name)
+		if id != tt.wantId || ok != tt.wantOk {
+			t.Errorf("findState(%q) = %d, %v, want %d, %v", tt.name, id, ok, tt.wantId, tt.wantOk)
+		}
+	}
+}
+
+func TestCanMove(t *testing.T) {
+	tests := []struct {
+		from, to int
+		want     bool
+	}{
+		{NoteFlagNote, NoteFlagNote, true},
+		{NoteFlagNote, NoteFlagInProgress, true},
+		{NoteFlagNote, NoteFlagCompleted, false},
+		{NoteFlagCancelled, NoteFlagNote, false},
+		{NoteFlagCancelled, NoteFlagCancelled, true},
+	}
+
+	for _, tt := range tests {
+		if got := moveWorkflow.canMove(tt.from, tt.to); got != tt.want {
+			t.Errorf("canMove(%d, %d) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestMoveNote(t *testing.T) {
+	completed := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		from, to     int
+		wantErr      string
+		wantFlag     int
+		wantTerminal bool
+		wantStamped  bool // the completion date is set to now
+	}{
+		{"allowed move", NoteFlagNote, NoteFlagInProgress, "", NoteFlagInProgress, false, false},
+		{"into a terminal state", NoteFlagInProgress, NoteFlagCompleted, "", NoteFlagCompleted, true, true},
+		{"staying in a terminal state keeps the date", NoteFlagCompleted, NoteFlagCompleted, "", NoteFlagCompleted, true, false},
+		{"move that isn't allowed", NoteFlagNote, NoteFlagCompleted, "a note can't go from Note to Completed", NoteFlagNote, false, false},
+		{"unknown state", NoteFlagNote, 99, "unknown note status", NoteFlagNote, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, _ := moveWorkflow.state(tt.from)
+			note := Note{Flag: tt.from, Terminal: from.Terminal, CompletionDate: completed}
+
+			if got := moveWorkflow.moveNote(&note, tt.to); got != tt.wantErr {
+				t.Fatalf("moveNote error = %q, want %q", got, tt.wantErr)
+			}
+			if note.Flag != tt.wantFlag || note.Terminal != tt.wantTerminal {
+				t.Errorf("note is in state %d (terminal %v), want %d (terminal %v)", note.Flag, note.Terminal, tt.wantFlag, tt.wantTerminal)
+			}
+			if stamped := !note.CompletionDate.Equal(completed); stamped != tt.wantStamped {
+				t.Errorf("completion date changed = %v, want %v", stamped, tt.wantStamped)
+			}
+		})
+	}
+}
+
+func TestStartNote(t *testing.T) {
+	var note Note
+	if got := moveWorkflow.startNote(&note, NoteFlagCancelled); got != "" {
+		t.Fatalf("startNote returned %q", got)
+	}
+	if note.Flag != NoteFlagCancelled || !note.Terminal {
+		t.Errorf("note is in state %d (terminal %v), want %d (terminal true)", note.Flag, note.Terminal, NoteFlagCancelled)
+	}
+
+	if got := moveWorkflow.startNote(&note, 99); got != "unknown note status" {
+		t.Errorf("startNote to an unknown state returned %q", got)
+	}
+}
+
+func TestCompletedCondition(t *testing.T) {
+	// An admin defined terminal state counts as completed, Cancelled doesn't
+	wf := Workflow{States: append(moveWorkflow.States, WorkflowState{Id: 7, Name: "Won't Fix", Terminal: true})}
+	want := "note_flag IN (" + strconv.Itoa(NoteFlagCompleted) + ", 7)"
+	if got := wf.completedCondition(); got != want {
+		t.Errorf("completedCondition() = %q, want %q", got, want)
+	}
+
+	open := Workflow{States: []WorkflowState{{Id: NoteFlagNote, Name: "Note"}, {Id: NoteFlagCancelled, Name: "Cancelled", Terminal: true}}}
+	if got := open.completedCondition(); got != "FALSE" {
+		t.Errorf("completedCondition() with no completing states = %q, want FALSE", got)
+	}
+}